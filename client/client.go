@@ -0,0 +1,405 @@
+// Package client is the Go client of the JSON/HTTP API of the log service.
+//
+//	c, err := client.New(client.Config{Addr: "http://localhost:8080"})
+//	off, err := c.Produce(ctx, []byte("hello world"))
+//	record, err := c.Consume(ctx, off)
+//
+// Requests that fail for a reason that may pass, the server being unreachable, busy or rate
+// limiting the client, are retried with exponential backoff. The errors of the server come back as
+// *Error, which the sentinel errors of this package match with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a record of the log
+type Record struct {
+	Value  []byte `json:"value"`
+	Offset uint64 `json:"offset"`
+}
+
+// Retry configures the retries of failed requests
+type Retry struct {
+	// MaxAttempts is how many times a request is tried, including the first. Zero means 3, one
+	// turns retries off.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled for every retry after it up to
+	// MaxBackoff. Zero means 100ms and 5s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Config holds what the client is built from
+type Config struct {
+	// Addr is the base URL of the server, e.g. https://localhost:8080
+	Addr string
+	// HTTPClient sends the requests, a client built from TLSConfig is used when it's nil
+	HTTPClient *http.Client
+	// TLSConfig is the client's TLS configuration, see internal/config.SetupTLSConfig
+	TLSConfig *tls.Config
+	// Token is sent as a bearer token when it's set
+	Token string
+	// Timeout bounds every attempt of a request, zero means no limit
+	Timeout time.Duration
+	Retry   Retry
+	// PollInterval is how often ConsumeStream asks for the next record once it has caught up with
+	// the log. Zero means 500ms.
+	PollInterval time.Duration
+}
+
+// Client is a client of the log service, safe for concurrent use
+type Client struct {
+	config Config
+	addr   string
+	http   *http.Client
+}
+
+// New returns a client of the server at config.Addr
+func New(config Config) (*Client, error) {
+	if config.Addr == "" {
+		return nil, errors.New("client: no server address")
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry.MaxAttempts = 3
+	}
+	if config.Retry.InitialBackoff == 0 {
+		config.Retry.InitialBackoff = 100 * time.Millisecond
+	}
+	if config.Retry.MaxBackoff == 0 {
+		config.Retry.MaxBackoff = 5 * time.Second
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = 500 * time.Millisecond
+	}
+	c := &Client{
+		config: config,
+		addr:   strings.TrimRight(config.Addr, "/"),
+		http:   config.HTTPClient,
+	}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config.TLSConfig
+		c.http = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// Produce appends the value to the log and returns its offset
+func (c *Client) Produce(ctx context.Context, value []byte) (uint64, error) {
+	req := struct {
+		Record Record `json:"record"`
+	}{Record{Value: value}}
+	var res struct {
+		Offset uint64 `json:"offset"`
+	}
+	// a produce that may have reached the log isn't retried, it could append the record twice
+	if err := c.do(ctx, http.MethodPost, "/", req, &res, false); err != nil {
+		return 0, err
+	}
+	return res.Offset, nil
+}
+
+// ProduceBatch appends the values in order and returns their offsets. When a value fails, the
+// offsets of the values appended before it are returned with the error.
+func (c *Client) ProduceBatch(ctx context.Context, values [][]byte) ([]uint64, error) {
+	offsets := make([]uint64, 0, len(values))
+	for i, value := range values {
+		off, err := c.Produce(ctx, value)
+		if err != nil {
+			return offsets, fmt.Errorf("client: producing value %d of the batch: %w", i, err)
+		}
+		offsets = append(offsets, off)
+	}
+	return offsets, nil
+}
+
+// Consume reads the record at the offset
+func (c *Client) Consume(ctx context.Context, offset uint64) (Record, error) {
+	var res Record
+	if err := c.do(ctx, http.MethodGet, "/records/"+strconv.FormatUint(offset, 10), nil, &res, true); err != nil {
+		return Record{}, err
+	}
+	return res, nil
+}
+
+// Page is a page of records, Next is the offset of the record after them
+type Page struct {
+	Records []Record `json:"records"`
+	Next    uint64   `json:"next"`
+}
+
+// ConsumeRange reads up to max records from the offset on, stopping before the record that would
+// take the size of the values past maxBytes. Zero max and maxBytes leave the limits to the server.
+// The page is empty when the log has no record at the offset yet.
+func (c *Client) ConsumeRange(ctx context.Context, from uint64, max int, maxBytes int) (Page, error) {
+	q := url.Values{"from": {strconv.FormatUint(from, 10)}}
+	if max > 0 {
+		q.Set("max", strconv.Itoa(max))
+	}
+	if maxBytes > 0 {
+		q.Set("maxBytes", strconv.Itoa(maxBytes))
+	}
+	var res Page
+	err := c.do(ctx, http.MethodGet, "/records?"+q.Encode(), nil, &res, true)
+	return res, err
+}
+
+// ConsumeStream calls fn with every record from the offset on, in order. Once it has caught up
+// with the log it polls for the next records every PollInterval. It returns when ctx is done, fn
+// returns an error, or the records can't be read.
+func (c *Client) ConsumeStream(ctx context.Context, offset uint64, fn func(Record) error) error {
+	for {
+		page, err := c.ConsumeRange(ctx, offset, 0, 0)
+		if err != nil {
+			return err
+		}
+		for _, record := range page.Records {
+			if err = fn(record); err != nil {
+				return err
+			}
+		}
+		if len(page.Records) == 0 {
+			if err = sleep(ctx, c.config.PollInterval); err != nil {
+				return err
+			}
+		}
+		offset = page.Next
+	}
+}
+
+// Offsets is the range of offsets in the log
+type Offsets struct {
+	Lowest  uint64 `json:"lowest"`
+	Highest uint64 `json:"highest"`
+}
+
+// Offsets returns the lowest and highest offsets of the log
+func (c *Client) Offsets(ctx context.Context) (Offsets, error) {
+	var res Offsets
+	err := c.do(ctx, http.MethodGet, "/offsets", nil, &res, true)
+	return res, err
+}
+
+// Truncate removes the segments of the log with no records above lowest, it needs the admin
+// permission. It returns the offsets of the log afterwards.
+func (c *Client) Truncate(ctx context.Context, lowest uint64) (Offsets, error) {
+	req := struct {
+		Lowest uint64 `json:"lowest"`
+	}{lowest}
+	var res Offsets
+	err := c.do(ctx, http.MethodPost, "/admin/truncate", req, &res, true)
+	return res, err
+}
+
+// Segment describes a segment of the log
+type Segment struct {
+	BaseOffset    uint64 `json:"base_offset"`
+	NextOffset    uint64 `json:"next_offset"`
+	StoreBytes    int64  `json:"store_bytes"`
+	IndexBytes    int64  `json:"index_bytes"`
+	Entries       uint64 `json:"entries"`
+	FormatVersion uint8  `json:"format_version"`
+	WideIndex     bool   `json:"wide_index"`
+	Active        bool   `json:"active"`
+}
+
+// Segments returns the segments of the log, oldest first, it needs the admin permission
+func (c *Client) Segments(ctx context.Context) ([]Segment, error) {
+	var res struct {
+		Segments []Segment `json:"segments"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/segments", nil, &res, true)
+	return res.Segments, err
+}
+
+// Roll starts a new active segment, it needs the admin permission. It returns the base offset of
+// the active segment afterwards.
+func (c *Client) Roll(ctx context.Context) (uint64, error) {
+	var res struct {
+		ActiveBaseOffset uint64 `json:"active_base_offset"`
+	}
+	err := c.do(ctx, http.MethodPost, "/admin/roll", nil, &res, false)
+	return res.ActiveBaseOffset, err
+}
+
+// Problem is an inconsistency Verify found in a segment
+type Problem struct {
+	BaseOffset uint64 `json:"base_offset"`
+	Message    string `json:"message"`
+}
+
+// Verify checks the segments of the log for inconsistencies, it needs the admin permission
+func (c *Client) Verify(ctx context.Context) ([]Problem, error) {
+	var res struct {
+		Problems []Problem `json:"problems"`
+	}
+	err := c.do(ctx, http.MethodPost, "/admin/verify", nil, &res, true)
+	return res.Problems, err
+}
+
+// do sends the request, retrying it when it fails with a retryable error. idempotent requests are
+// retried after any such failure, the others only when they can't have reached the server.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, idempotent bool) error {
+	var body []byte
+	var err error
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	backoff := c.config.Retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = c.attempt(ctx, method, path, body, out)
+		if err == nil || attempt >= c.config.Retry.MaxAttempts || !retryable(err, idempotent) {
+			return err
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		var serr *Error
+		if errors.As(err, &serr) && serr.RetryAfter > wait {
+			wait = serr.RetryAfter
+		}
+		if sleep(ctx, wait) != nil {
+			return err
+		}
+		if backoff *= 2; backoff > c.config.Retry.MaxBackoff {
+			backoff = c.config.Retry.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return newError(res)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// retryable reports whether the request may succeed when it is sent again
+func retryable(err error, idempotent bool) bool {
+	var serr *Error
+	if errors.As(err, &serr) {
+		switch serr.StatusCode {
+		case http.StatusTooManyRequests:
+			// rate limited requests are refused before they are handled
+			return true
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return idempotent
+		}
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// the attempt's timeout runs out, not the caller's context, when the caller's is still live
+		return idempotent
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return idempotent
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Errors the *Error of the server match, by the code of the error response
+var (
+	ErrBadRequest       = errors.New("client: bad request")
+	ErrUnauthenticated  = errors.New("client: unauthenticated")
+	ErrPermissionDenied = errors.New("client: permission denied")
+	ErrOffsetOutOfRange = errors.New("client: offset out of range")
+	ErrRecordTooLarge   = errors.New("client: record too large")
+	ErrRateLimited      = errors.New("client: rate limited")
+	ErrUnavailable      = errors.New("client: server unavailable")
+)
+
+var codeErrors = map[string]error{
+	"bad_request":         ErrBadRequest,
+	"unauthenticated":     ErrUnauthenticated,
+	"permission_denied":   ErrPermissionDenied,
+	"offset_out_of_range": ErrOffsetOutOfRange,
+	"record_too_large":    ErrRecordTooLarge,
+	"rate_limited":        ErrRateLimited,
+	"log_closed":          ErrUnavailable,
+}
+
+// Error is an error response of the server
+type Error struct {
+	StatusCode int
+	// Code and Message are the code and error of the response's body, Code is empty when the
+	// response didn't come from the log service
+	Code    string
+	Message string
+	// RetryAfter is how long the server asked the client to wait before trying again
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("client: server responded %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("client: server responded %d %s (%s): %s", e.StatusCode, http.StatusText(e.StatusCode), e.Code, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	if err, ok := codeErrors[e.Code]; ok {
+		return err == target
+	}
+	return target == ErrUnavailable && (e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusBadGateway)
+}
+
+func newError(res *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64<<10))
+	e := &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(b))}
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.Unmarshal(b, &body) == nil && body.Code != "" {
+		e.Code, e.Message = body.Code, body.Error
+	}
+	if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hamza-yusuff/proglog/internal/log"
+	"github.com/hamza-yusuff/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTest(t *testing.T) (*Client, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "client-test")
+	require.NoError(t, err)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	// the admin endpoints are used without a policy
+	srv, err := server.NewHTTPServer(":0", &server.Config{CommitLog: clog, OpenAdmin: true})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+
+	c, err := New(Config{Addr: ts.URL, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	return c, func() {
+		ts.Close()
+		clog.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProduceConsume(t *testing.T) {
+	c, teardown := setupTest(t)
+	defer teardown()
+	ctx := context.Background()
+
+	off, err := c.Produce(ctx, []byte("hello world"))
+	require.NoError(t, err)
+	record, err := c.Consume(ctx, off)
+	require.NoError(t, err)
+	require.Equal(t, Record{Value: []byte("hello world"), Offset: off}, record)
+
+	offsets, err := c.ProduceBatch(ctx, [][]byte{[]byte("first"), []byte("second")})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, offsets)
+
+	page, err := c.ConsumeRange(ctx, 1, 5, 0)
+	require.NoError(t, err)
+	require.Equal(t, Page{Records: []Record{
+		{Value: []byte("first"), Offset: 1},
+		{Value: []byte("second"), Offset: 2},
+	}, Next: 3}, page)
+
+	_, err = c.Consume(ctx, 10)
+	require.True(t, errors.Is(err, ErrOffsetOutOfRange))
+	var serr *Error
+	require.True(t, errors.As(err, &serr))
+	require.Equal(t, http.StatusNotFound, serr.StatusCode)
+}
+
+func TestConsumeStream(t *testing.T) {
+	c, teardown := setupTest(t)
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.Produce(ctx, []byte("first"))
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Produce(ctx, []byte("second"))
+	}()
+
+	var got []string
+	done := errors.New("done")
+	err = c.ConsumeStream(ctx, 0, func(r Record) error {
+		got = append(got, string(r.Value))
+		if len(got) == 2 {
+			return done
+		}
+		return nil
+	})
+	require.Equal(t, done, err)
+	require.Equal(t, []string{"first", "second"}, got)
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"value": "aGk=", "offset": 0}`))
+	}))
+	defer ts.Close()
+
+	c, err := New(Config{Addr: ts.URL, Retry: Retry{InitialBackoff: time.Millisecond}})
+	require.NoError(t, err)
+	record, err := c.Consume(context.Background(), 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hi"), record.Value)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// produce isn't retried after the server may have appended the record
+	atomic.StoreInt32(&calls, 0)
+	_, err = c.Produce(context.Background(), []byte("hi"))
+	require.True(t, errors.Is(err, ErrUnavailable))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestOffsetsTruncate(t *testing.T) {
+	c, teardown := setupTest(t)
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := c.ProduceBatch(ctx, [][]byte{[]byte("first"), []byte("second")})
+	require.NoError(t, err)
+	offsets, err := c.Offsets(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Offsets{Lowest: 0, Highest: 1}, offsets)
+	offsets, err = c.Truncate(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, Offsets{Lowest: 0, Highest: 1}, offsets)
+
+	// the segment of offsets 0 and 1 and the one of 2 have no records above 2
+	_, err = c.Roll(ctx)
+	require.NoError(t, err)
+	_, err = c.Produce(ctx, []byte("third"))
+	require.NoError(t, err)
+	_, err = c.Roll(ctx)
+	require.NoError(t, err)
+	_, err = c.Produce(ctx, []byte("fourth"))
+	require.NoError(t, err)
+	offsets, err = c.Truncate(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, Offsets{Lowest: 3, Highest: 3}, offsets)
+}
+
+func TestAdmin(t *testing.T) {
+	c, teardown := setupTest(t)
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := c.ProduceBatch(ctx, [][]byte{[]byte("first"), []byte("second")})
+	require.NoError(t, err)
+	base, err := c.Roll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), base)
+
+	segments, err := c.Segments(ctx)
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.Equal(t, uint64(2), segments[0].NextOffset)
+	require.True(t, segments[1].Active)
+
+	problems, err := c.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
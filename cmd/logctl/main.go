@@ -0,0 +1,305 @@
+// logctl produces records to, consumes records from and administers a running log server.
+//
+//	logctl [flags] produce [-lines] [file ...]
+//	logctl [flags] consume [-offset n] [-n count] [-follow] [-format raw|json|hex]
+//	logctl [flags] offsets
+//	logctl [flags] truncate -lowest n
+//	logctl [flags] segments
+//	logctl [flags] roll
+//	logctl [flags] verify
+//
+// produce appends the contents of every file, or of stdin without files, as one record, or every
+// line as a record with -lines. consume prints the records from the offset until the end of the
+// log, or waits for new ones with -follow. truncate, segments, roll and verify use the admin
+// endpoints, verify exits with 1 when it finds problems.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/hamza-yusuff/proglog/client"
+	"github.com/hamza-yusuff/proglog/internal/config"
+)
+
+const usage = `usage: logctl [flags] <command> [command flags]
+
+commands:
+  produce [-lines] [file ...]   append records from the files, or stdin
+  consume [-offset n] [-n count] [-follow] [-format raw|json|hex]
+                                print the records from the offset on
+  offsets                       print the lowest and highest offsets
+  truncate -lowest n            remove the segments with no records above the offset
+  segments                      list the segments
+  roll                          start a new active segment
+  verify                        check the segments for inconsistencies
+
+flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	addr := flag.String("addr", "http://localhost:8080", "address of the server")
+	token := flag.String("token", os.Getenv("LOGCTL_TOKEN"), "bearer token, defaults to $LOGCTL_TOKEN")
+	var tlsConfig config.TLSConfig
+	flag.StringVar(&tlsConfig.CAFile, "tls-ca", "", "CA bundle to verify the server with")
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "client certificate file, for mutual TLS")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "key file of the client certificate")
+	flag.StringVar(&tlsConfig.ServerAddress, "tls-server-name", "", "name to verify the server's certificate against")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cc := client.Config{Addr: *addr, Token: *token}
+	if tlsConfig.CAFile != "" || tlsConfig.CertFile != "" {
+		tc, err := config.SetupTLSConfig(tlsConfig)
+		if err != nil {
+			fatal(err)
+		}
+		cc.TLSConfig = tc
+	}
+	c, err := client.New(cc)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "produce":
+		err = produce(ctx, c, args)
+	case "consume":
+		err = consume(ctx, c, args)
+	case "offsets":
+		err = offsets(ctx, c)
+	case "truncate":
+		err = truncate(ctx, c, args)
+	case "segments":
+		err = segments(ctx, c)
+	case "roll":
+		err = roll(ctx, c)
+	case "verify":
+		err = verify(ctx, c)
+	default:
+		fmt.Fprintf(os.Stderr, "logctl: unknown command %q\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal(err)
+	}
+}
+
+func produce(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("produce", flag.ExitOnError)
+	lines := fs.Bool("lines", false, "append every line as a record")
+	fs.Parse(args)
+
+	var inputs []io.Reader
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		inputs = append(inputs, f)
+	}
+	if len(inputs) == 0 {
+		inputs = append(inputs, os.Stdin)
+	}
+
+	for _, in := range inputs {
+		if *lines {
+			if err := produceLines(ctx, c, in); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		off, err := c.Produce(ctx, b)
+		if err != nil {
+			return err
+		}
+		fmt.Println(off)
+	}
+	return nil
+}
+
+// produceLines appends every line as soon as it has been read, so the records of an input that
+// doesn't end, like tail -f, are produced as they come
+func produceLines(ctx context.Context, c *client.Client, in io.Reader) error {
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 64<<10), 64<<20)
+	for s.Scan() {
+		off, err := c.Produce(ctx, s.Bytes())
+		if err != nil {
+			return err
+		}
+		fmt.Println(off)
+	}
+	return s.Err()
+}
+
+func consume(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("consume", flag.ExitOnError)
+	offset := fs.Uint64("offset", 0, "offset of the first record")
+	count := fs.Uint64("n", 0, "number of records to print, zero means all of them")
+	follow := fs.Bool("follow", false, "wait for new records at the end of the log")
+	format := fs.String("format", "raw", "output format: raw, json or hex")
+	fs.Parse(args)
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	printRecord, err := printer(w, *format)
+	if err != nil {
+		return err
+	}
+
+	var printed uint64
+	errDone := errors.New("done")
+	fn := func(r client.Record) error {
+		if err := printRecord(r); err != nil {
+			return err
+		}
+		if printed++; *count > 0 && printed >= *count {
+			return errDone
+		}
+		if *follow {
+			return w.Flush()
+		}
+		return nil
+	}
+	if *follow {
+		if err = c.ConsumeStream(ctx, *offset, fn); err == errDone {
+			err = nil
+		}
+		return err
+	}
+	for off := *offset; ; {
+		page, err := c.ConsumeRange(ctx, off, 0, 0)
+		if err != nil {
+			return err
+		}
+		if len(page.Records) == 0 {
+			return nil
+		}
+		for _, r := range page.Records {
+			if err = fn(r); err == errDone {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		off = page.Next
+	}
+}
+
+// printer returns the function that prints a record in the format
+func printer(w io.Writer, format string) (func(client.Record) error, error) {
+	switch format {
+	case "raw":
+		return func(r client.Record) error {
+			_, err := fmt.Fprintf(w, "%s\n", r.Value)
+			return err
+		}, nil
+	case "json":
+		enc := json.NewEncoder(w)
+		return func(r client.Record) error {
+			return enc.Encode(r)
+		}, nil
+	case "hex":
+		return func(r client.Record) error {
+			_, err := fmt.Fprintf(w, "%d\t%s\n", r.Offset, hex.EncodeToString(r.Value))
+			return err
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func offsets(ctx context.Context, c *client.Client) error {
+	o, err := c.Offsets(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("lowest\t%d\nhighest\t%d\n", o.Lowest, o.Highest)
+	return nil
+}
+
+func truncate(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("truncate", flag.ExitOnError)
+	lowest := fs.Int64("lowest", -1, "remove the segments whose records are all at or below this offset")
+	fs.Parse(args)
+	if *lowest < 0 {
+		return errors.New("truncate needs -lowest")
+	}
+	o, err := c.Truncate(ctx, uint64(*lowest))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("lowest\t%d\nhighest\t%d\n", o.Lowest, o.Highest)
+	return nil
+}
+
+func segments(ctx context.Context, c *client.Client) error {
+	segments, err := c.Segments(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "BASE\tNEXT\tENTRIES\tSTORE BYTES\tINDEX BYTES\tVERSION\tACTIVE")
+	for _, s := range segments {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%t\n",
+			s.BaseOffset, s.NextOffset, s.Entries, s.StoreBytes, s.IndexBytes, s.FormatVersion, s.Active,
+		)
+	}
+	return tw.Flush()
+}
+
+func roll(ctx context.Context, c *client.Client) error {
+	base, err := c.Roll(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("active\t%d\n", base)
+	return nil
+}
+
+func verify(ctx context.Context, c *client.Client) error {
+	problems, err := c.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Printf("segment %d: %s\n", p.BaseOffset, p.Message)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "logctl: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,186 @@
+// loginspect looks inside the segment files of a log directory without changing them.
+//
+//	loginspect [-dir data] segments
+//	loginspect [-dir data] index [-segment base]
+//	loginspect [-dir data] records [-segment base] [-format raw|json|hex]
+//	loginspect [-dir data] check
+//
+// segments lists the segments with their offsets and sizes, index dumps the entries of the
+// indexes, records decodes the records the entries point at, and check reports the
+// inconsistencies between the indexes, the stores and the manifest, exiting with 1 when it
+// finds any.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/hamza-yusuff/proglog/internal/log"
+)
+
+const usage = `usage: loginspect [-dir dir] <command> [command flags]
+
+commands:
+  segments                       list the segments
+  index [-segment base]          dump the index entries
+  records [-segment base] [-format raw|json|hex]
+                                 decode the records
+  check                          report inconsistencies
+
+flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	dir := flag.String("dir", "data", "log directory")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	info, err := log.Inspect(*dir)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "segments":
+		err = segments(w, info)
+	case "index":
+		err = index(w, info, args)
+	case "records":
+		err = records(w, info, args)
+	case "check":
+		var ok bool
+		if ok, err = check(w, info); err == nil && !ok {
+			w.Flush()
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "loginspect: unknown command %q\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		w.Flush()
+		fatal(err)
+	}
+}
+
+func segments(w io.Writer, info *log.LogInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "BASE\tNEXT\tENTRIES\tUNUSED\tSTORE BYTES\tINDEX BYTES\tVERSION\tWIDE INDEX\tIN MANIFEST")
+	for _, s := range info.Segments {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%t\t%t\n",
+			s.BaseOffset, s.NextOffset, s.Entries, s.UnusedEntries,
+			s.StoreBytes, s.IndexBytes, s.FormatVersion, s.WideIndex, s.InManifest,
+		)
+	}
+	return tw.Flush()
+}
+
+// pick returns the segment with the base offset, or all of them when base is negative
+func pick(info *log.LogInfo, base int64) ([]log.SegmentInfo, error) {
+	if base < 0 {
+		return info.Segments, nil
+	}
+	for _, s := range info.Segments {
+		if s.BaseOffset == uint64(base) {
+			return []log.SegmentInfo{s}, nil
+		}
+	}
+	return nil, fmt.Errorf("no segment with base offset %d", base)
+}
+
+const segmentUsage = "base offset of the segment, all of them when not set"
+
+func index(w io.Writer, info *log.LogInfo, args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	base := fs.Int64("segment", -1, segmentUsage)
+	fs.Parse(args)
+	segs, err := pick(info, *base)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEGMENT\tOFFSET\tRELATIVE\tPOSITION")
+	for _, s := range segs {
+		entries, err := s.IndexEntries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%d\n", s.BaseOffset, e.Offset, e.RelOffset, e.Pos)
+		}
+	}
+	return tw.Flush()
+}
+
+func records(w io.Writer, info *log.LogInfo, args []string) error {
+	fs := flag.NewFlagSet("records", flag.ExitOnError)
+	base := fs.Int64("segment", -1, segmentUsage)
+	format := fs.String("format", "raw", "output format: raw, json or hex")
+	fs.Parse(args)
+	segs, err := pick(info, *base)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, s := range segs {
+		err := s.Records(func(e log.IndexEntry, r *api.Record) error {
+			switch *format {
+			case "raw":
+				_, err := fmt.Fprintf(w, "%s\n", r.Value)
+				return err
+			case "json":
+				return enc.Encode(struct {
+					Offset   uint64 `json:"offset"`
+					Position uint64 `json:"position"`
+					Value    []byte `json:"value"`
+				}{r.Offset, e.Pos, r.Value})
+			case "hex":
+				_, err := fmt.Fprintf(w, "%d\t%d\t%s\n", r.Offset, e.Pos, hex.EncodeToString(r.Value))
+				return err
+			}
+			return fmt.Errorf("unknown format %q", *format)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check prints the problems and reports whether there were none
+func check(w io.Writer, info *log.LogInfo) (bool, error) {
+	problems, err := info.Check()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "%d segments, no problems found\n", len(info.Segments))
+	}
+	return len(problems) == 0, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "loginspect: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,45 @@
+package main
+
+// logmigrate converts a log directory to the latest on-disk format. The server must be stopped
+// while it runs. The segment config is read from the manifest of the log, logs written before the
+// manifest existed need it passed with the flags.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hamza-yusuff/proglog/internal/log"
+)
+
+func main() {
+	dir := flag.String("dir", "", "log directory to migrate")
+	maxStoreBytes := flag.Uint64("max-store-bytes", 1024, "max store bytes, for logs without a manifest")
+	maxIndexBytes := flag.Uint64("max-index-bytes", 1024, "max index bytes, for logs without a manifest")
+	initialOffset := flag.Uint64("initial-offset", 0, "initial offset, for logs without a manifest")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "logmigrate: -dir is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, ok, err := log.ReadConfig(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logmigrate: %v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		c.Segment.MaxStoreBytes = *maxStoreBytes
+		c.Segment.MaxIndexBytes = *maxIndexBytes
+		c.Segment.InitialOffset = *initialOffset
+	}
+
+	n, err := log.Migrate(*dir, c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logmigrate: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("migrated %d segment(s) in %s\n", n, *dir)
+}
@@ -0,0 +1,248 @@
+package main
+
+// Configuration of the server. Every setting has a flag, an environment variable named after the
+// flag, PROGLOG_ followed by the flag's name in upper case with underscores, and a key of the
+// YAML file of -config:
+//
+//	data_dir: /var/lib/proglog
+//	http_addr: ":8080"
+//	segment:
+//	  max_store_bytes: 1048576
+//	retention:
+//	  max_age: 168h
+//	rate_limits:
+//	  produce_requests:
+//	    rate: 100
+//	    burst: 200
+//
+// Flags win over the environment, which wins over the file, which wins over the defaults.
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server
+type Config struct {
+	DataDir  string `yaml:"data_dir"`
+	HTTPAddr string `yaml:"http_addr"`
+	GRPCAddr string `yaml:"grpc_addr"`
+	// RequestTimeout bounds how long an HTTP request may wait on the log, zero means no limit
+	RequestTimeout  time.Duration `yaml:"request_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Segment, Cache and Retention are the settings of internal/log.Config of the same names. The
+	// segment sizes and the initial offset can't change once a log has been created.
+	Segment struct {
+		MaxStoreBytes   uint64 `yaml:"max_store_bytes"`
+		MaxIndexBytes   uint64 `yaml:"max_index_bytes"`
+		InitialOffset   uint64 `yaml:"initial_offset"`
+		MaxOpenSegments int    `yaml:"max_open_segments"`
+		SyncWrites      bool   `yaml:"sync_writes"`
+		MaxRecordBytes  uint64 `yaml:"max_record_bytes"`
+		FormatVersion   uint   `yaml:"format_version"`
+		WideIndex       bool   `yaml:"wide_index"`
+	} `yaml:"segment"`
+	Cache struct {
+		MaxBytes uint64 `yaml:"max_bytes"`
+	} `yaml:"cache"`
+	Retention struct {
+		MaxBytes uint64        `yaml:"max_bytes"`
+		MaxAge   time.Duration `yaml:"max_age"`
+		// Interval is how often the retention limits are applied
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"retention"`
+
+	TLS struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		CAFile   string `yaml:"ca_file"`
+	} `yaml:"tls"`
+	Auth struct {
+		ACLPolicy string `yaml:"acl_policy"`
+		TokenFile string `yaml:"token_file"`
+		OpenAdmin bool   `yaml:"open_admin"`
+	} `yaml:"auth"`
+
+	// RateLimits are the per client limits of internal/server.RateLimits, there are none while
+	// every rate is zero
+	RateLimits struct {
+		ProduceRequests Limit `yaml:"produce_requests"`
+		ProduceBytes    Limit `yaml:"produce_bytes"`
+		ConsumeRequests Limit `yaml:"consume_requests"`
+		ConsumeBytes    Limit `yaml:"consume_bytes"`
+	} `yaml:"rate_limits"`
+}
+
+// Limit is a rate limit, see internal/server.Limit
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst float64 `yaml:"burst"`
+}
+
+// limited tells whether any of the rate limits is set
+func (c *Config) limited() bool {
+	for _, l := range c.limits() {
+		if l.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) limits() map[string]*Limit {
+	return map[string]*Limit{
+		"produce-requests": &c.RateLimits.ProduceRequests,
+		"produce-bytes":    &c.RateLimits.ProduceBytes,
+		"consume-requests": &c.RateLimits.ConsumeRequests,
+		"consume-bytes":    &c.RateLimits.ConsumeBytes,
+	}
+}
+
+// envPrefix is the prefix of the environment variables of the settings
+const envPrefix = "PROGLOG_"
+
+// loadConfig reads the configuration from the flags in args, the environment and the config file
+func loadConfig(args []string) (*Config, error) {
+	c := &Config{}
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML config file")
+	fs.StringVar(&c.DataDir, "data-dir", "data", "directory of the segments of the log")
+	fs.StringVar(&c.HTTPAddr, "http-addr", ":8080", "address of the JSON/HTTP API")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", ":8400", "address of the gRPC API")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", 0, "how long an HTTP request may wait on the log, 0 for no limit")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for requests in flight on SIGINT or SIGTERM")
+	fs.Uint64Var(&c.Segment.MaxStoreBytes, "segment-max-store-bytes", 1024, "size of the store file of a segment")
+	// 100 entries of 12 bytes, or 75 of a wide index's 16
+	fs.Uint64Var(&c.Segment.MaxIndexBytes, "segment-max-index-bytes", 1200, "size of the index file of a segment")
+	fs.Uint64Var(&c.Segment.InitialOffset, "segment-initial-offset", 0, "offset of the first record of a new log")
+	fs.IntVar(&c.Segment.MaxOpenSegments, "segment-max-open", 0, "most segments with their files open, 0 for no limit")
+	fs.BoolVar(&c.Segment.SyncWrites, "segment-sync-writes", false, "sync every append to disk before acknowledging it")
+	fs.Uint64Var(&c.Segment.MaxRecordBytes, "segment-max-record-bytes", 0, "most bytes a record may take in a store, 0 for no limit")
+	fs.UintVar(&c.Segment.FormatVersion, "segment-format-version", 0, "on-disk format of new segments, 0 for the latest")
+	fs.BoolVar(&c.Segment.WideIndex, "segment-wide-index", false, "store 8 byte relative offsets in new indexes, requires format version 2")
+	fs.Uint64Var(&c.Cache.MaxBytes, "cache-max-bytes", 0, "most bytes of recently read records to cache, 0 disables the cache")
+	fs.Uint64Var(&c.Retention.MaxBytes, "retention-max-bytes", 0, "most bytes of records to keep, 0 for no limit")
+	fs.DurationVar(&c.Retention.MaxAge, "retention-max-age", 0, "how long to keep a segment after its last append, 0 for no limit")
+	fs.DurationVar(&c.Retention.Interval, "retention-interval", time.Minute, "how often to apply the retention limits")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", "", "certificate file, serves TLS when set")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", "", "key file of the certificate")
+	fs.StringVar(&c.TLS.CAFile, "tls-ca", "", "CA bundle to verify client certificates with, requires them when set")
+	fs.StringVar(&c.Auth.ACLPolicy, "acl-policy", "", "access control policy file, without one everyone may produce and consume and no one may use the admin endpoints")
+	fs.StringVar(&c.Auth.TokenFile, "token-file", "", "file of the hashed bearer tokens the HTTP API accepts")
+	fs.BoolVar(&c.Auth.OpenAdmin, "open-admin", false, "serve the admin endpoints and /metrics to anonymous clients, and to everyone without an acl-policy")
+	for name, l := range c.limits() {
+		unit := strings.SplitN(name, "-", 2)[1]
+		fs.Float64Var(&l.Rate, "rate-limit-"+name, 0, unit+" per second a client may "+strings.SplitN(name, "-", 2)[0]+", 0 for no limit")
+		fs.Float64Var(&l.Burst, "rate-limit-"+name+"-burst", 0, "burst of rate-limit-"+name+", 0 for a second's worth")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// the flags that were set are set again once the file and the environment have been read
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = f.Value.String() })
+
+	if *configFile == "" {
+		*configFile = os.Getenv(envName("config"))
+	}
+	if *configFile != "" {
+		if err := c.readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil || f.Name == "config" {
+			return
+		}
+		if setErr := fs.Set(f.Name, v); setErr != nil {
+			err = fmt.Errorf("%s=%q: %v", envName(f.Name), v, setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range set {
+		if err := fs.Set(name, v); err != nil {
+			return nil, err
+		}
+	}
+	return c, c.validate()
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readFile reads the YAML file over c, the settings it doesn't have are left alone
+func (c *Config) readFile(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.DataDir != "", "data-dir must be set")
+	check(c.HTTPAddr != "", "http-addr must be set")
+	check(c.GRPCAddr != "", "grpc-addr must be set")
+	check(c.HTTPAddr != c.GRPCAddr, "http-addr and grpc-addr must differ")
+	check(c.RequestTimeout >= 0, "request-timeout must not be negative")
+	check(c.ShutdownTimeout >= 0, "shutdown-timeout must not be negative")
+	check(c.Segment.MaxStoreBytes > 0, "segment-max-store-bytes must be positive")
+	entWidth := uint64(12)
+	if c.Segment.WideIndex {
+		entWidth = 16
+	}
+	check(c.Segment.MaxIndexBytes >= entWidth, "segment-max-index-bytes must have room for an index entry of %d bytes", entWidth)
+	check(c.Segment.MaxOpenSegments >= 0, "segment-max-open must not be negative")
+	check(c.Segment.FormatVersion <= 2, "segment-format-version must be 0, 1 or 2")
+	check(!c.Segment.WideIndex || c.Segment.FormatVersion != 1, "segment-wide-index requires format version 2")
+	for name, l := range c.limits() {
+		check(l.Rate >= 0 && l.Burst >= 0, "rate-limit-%s and its burst must not be negative", name)
+	}
+	check(c.Retention.MaxAge >= 0, "retention-max-age must not be negative")
+	check(c.Retention.Interval > 0, "retention-interval must be positive")
+	check(c.TLS.CertFile == "" || c.TLS.KeyFile != "", "tls-key must be set with tls-cert")
+	check(c.TLS.KeyFile == "" || c.TLS.CertFile != "", "tls-cert must be set with tls-key")
+	check(c.TLS.CAFile == "" || c.TLS.CertFile != "", "tls-ca requires tls-cert and tls-key")
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// print writes the effective configuration as YAML, the config file the server could be started
+// with to get the same settings
+func (c *Config) print(w io.Writer) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "effective configuration:\n%s", b)
+	return err
+}
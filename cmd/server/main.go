@@ -1,12 +1,188 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	"github.com/hamza-yusuff/proglog/internal/config"
+	"github.com/hamza-yusuff/proglog/internal/log"
 	"github.com/hamza-yusuff/proglog/internal/server"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	srv := server.NewHTTPServer(":8080")
-	log.Fatal(srv.ListenAndServe())
+	c, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if err = c.print(os.Stderr); err != nil {
+		fatal(err)
+	}
+
+	logConfig := log.Config{}
+	logConfig.Segment.MaxStoreBytes = c.Segment.MaxStoreBytes
+	logConfig.Segment.MaxIndexBytes = c.Segment.MaxIndexBytes
+	logConfig.Segment.InitialOffset = c.Segment.InitialOffset
+	logConfig.Segment.MaxOpenSegments = c.Segment.MaxOpenSegments
+	logConfig.Segment.SyncWrites = c.Segment.SyncWrites
+	logConfig.Segment.MaxRecordBytes = c.Segment.MaxRecordBytes
+	logConfig.Segment.FormatVersion = uint8(c.Segment.FormatVersion)
+	logConfig.Segment.WideIndex = c.Segment.WideIndex
+	logConfig.Cache.MaxBytes = c.Cache.MaxBytes
+	logConfig.Retention.MaxBytes = c.Retention.MaxBytes
+	logConfig.Retention.MaxAge = c.Retention.MaxAge
+	commitLog, err := log.NewLog(c.DataDir, logConfig)
+	if err != nil {
+		fatal(err)
+	}
+	srvConfig := &server.Config{
+		CommitLog:      commitLog,
+		RequestTimeout: c.RequestTimeout,
+		OpenAdmin:      c.Auth.OpenAdmin,
+	}
+	if c.limited() {
+		limits := c.RateLimits
+		srvConfig.RateLimits = &server.RateLimits{
+			ProduceRequests: server.Limit(limits.ProduceRequests),
+			ProduceBytes:    server.Limit(limits.ProduceBytes),
+			ConsumeRequests: server.Limit(limits.ConsumeRequests),
+			ConsumeBytes:    server.Limit(limits.ConsumeBytes),
+		}
+	}
+	if c.TLS.CertFile != "" {
+		tlsConfig := config.TLSConfig{
+			CertFile: c.TLS.CertFile,
+			KeyFile:  c.TLS.KeyFile,
+			CAFile:   c.TLS.CAFile,
+			Server:   true,
+		}
+		if srvConfig.TLSConfig, err = config.SetupTLSConfig(tlsConfig); err != nil {
+			fatal(err)
+		}
+	}
+	if c.Auth.ACLPolicy != "" {
+		policy, err := auth.ReadPolicy(c.Auth.ACLPolicy)
+		if err != nil {
+			fatal(err)
+		}
+		if srvConfig.Authorizer, err = auth.New(policy); err != nil {
+			fatal(err)
+		}
+	}
+	if c.Auth.TokenFile != "" {
+		if srvConfig.Tokens, err = auth.NewTokenStore(c.Auth.TokenFile); err != nil {
+			fatal(err)
+		}
+	}
+
+	srv, err := server.NewHTTPServer(c.HTTPAddr, srvConfig)
+	if err != nil {
+		fatal(err)
+	}
+	gsrv, err := server.NewGRPCServer(srvConfig)
+	if err != nil {
+		fatal(err)
+	}
+	l, err := net.Listen("tcp", c.GRPCAddr)
+	if err != nil {
+		fatal(err)
+	}
+
+	// the servers run until one of them fails or the process is told to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 2)
+	go func() { errc <- serveHTTP(srv) }()
+	go func() { errc <- gsrv.Serve(l) }()
+	if c.Retention.MaxBytes > 0 || c.Retention.MaxAge > 0 {
+		go applyRetention(ctx, commitLog, c.Retention.Interval)
+	}
+	var serveErr error
+	select {
+	case serveErr = <-errc:
+	case <-ctx.Done():
+	}
+	stop()
+
+	// the requests in flight are given shutdownTimeout to finish, the log is closed after them so
+	// its indexes are synced and truncated for the next start
+	sctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		srv.Close()
+	}
+	// the WebSocket connections aren't waited for by Shutdown, their appends are drained here
+	if err := server.WaitWebSockets(sctx, srv); err != nil {
+		fmt.Fprintf(os.Stderr, "server: WebSocket connections still open: %v\n", err)
+	}
+	stopGRPC(sctx, gsrv)
+	if err := commitLog.Close(); err != nil {
+		fatal(err)
+	}
+	if serveErr != nil {
+		fatal(serveErr)
+	}
+}
+
+// applyRetention removes the segments past the retention limits every interval until ctx is done.
+// A failure is only logged, the log is tried again at the next interval.
+func applyRetention(ctx context.Context, commitLog *log.Log, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := commitLog.ApplyRetention(); err != nil {
+				fmt.Fprintf(os.Stderr, "server: retention: %v\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stopGRPC stops the gRPC server gracefully, or right away when ctx is done first
+func stopGRPC(ctx context.Context, gsrv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gsrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		gsrv.Stop()
+		<-stopped
+	}
+}
+
+// serveHTTP serves until the server fails, or is shut down which isn't a failure
+func serveHTTP(srv *http.Server) error {
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "server: %v\n", err)
+	os.Exit(1)
 }
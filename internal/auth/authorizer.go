@@ -0,0 +1,131 @@
+package auth
+
+// Access control for the log. A policy is a list of rules, each allowing a subject, the identity
+// of a client, some actions on some logs. Everything no rule allows is denied. The policy file is
+// JSON:
+//
+//	{
+//	  "rules": [
+//	    {"subject": "alice", "actions": ["produce", "consume"], "logs": ["*"]},
+//	    {"subject": "ops", "actions": ["admin"]},
+//	    {"subject": "*", "actions": ["consume"], "logs": ["public"]}
+//	  ]
+//	}
+//
+// "*" matches any subject, action or log, a subject of "*" matches clients without an identity
+// too. A rule without logs applies to every log.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Actions the policy allows
+const (
+	ActionProduce = "produce"
+	ActionConsume = "consume"
+	ActionAdmin   = "admin"
+
+	Wildcard = "*"
+)
+
+// ErrPermissionDenied is returned by Authorize for the requests the policy doesn't allow
+var ErrPermissionDenied = errors.New("auth: permission denied")
+
+// PermissionDeniedError says who was denied what
+type PermissionDeniedError struct {
+	Subject string
+	Object  string
+	Action  string
+}
+
+func (e *PermissionDeniedError) Error() string {
+	subject := e.Subject
+	if subject == "" {
+		subject = "anonymous client"
+	}
+	return fmt.Sprintf("auth: %s not permitted to %s on %s", subject, e.Action, e.Object)
+}
+
+func (e *PermissionDeniedError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+// Rule allows the subject the actions on the logs
+type Rule struct {
+	Subject string   `json:"subject"`
+	Actions []string `json:"actions"`
+	Logs    []string `json:"logs,omitempty"`
+}
+
+// Policy is the list of rules of an Authorizer
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// ReadPolicy reads the policy from a JSON file
+func ReadPolicy(file string) (Policy, error) {
+	var p Policy
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return p, err
+	}
+	if err = json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("auth: invalid policy file %q: %v", file, err)
+	}
+	return p, nil
+}
+
+// Authorizer decides which requests the policy allows
+type Authorizer struct {
+	policy Policy
+}
+
+// New returns the Authorizer of the policy, after checking its rules
+func New(p Policy) (*Authorizer, error) {
+	for i, r := range p.Rules {
+		if r.Subject == "" {
+			return nil, fmt.Errorf("auth: rule %d has no subject", i)
+		}
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("auth: rule %d has no actions", i)
+		}
+		for _, a := range r.Actions {
+			switch a {
+			case ActionProduce, ActionConsume, ActionAdmin, Wildcard:
+			default:
+				return nil, fmt.Errorf("auth: rule %d has an unknown action %q", i, a)
+			}
+		}
+	}
+	return &Authorizer{policy: p}, nil
+}
+
+// Authorize returns nil when the subject may do the action on the object, a log, and a
+// *PermissionDeniedError otherwise
+func (a *Authorizer) Authorize(subject, object, action string) error {
+	for _, r := range a.policy.Rules {
+		if r.Subject != Wildcard && r.Subject != subject {
+			continue
+		}
+		if !contains(r.Actions, action) {
+			continue
+		}
+		if len(r.Logs) == 0 || contains(r.Logs, object) {
+			return nil
+		}
+	}
+	return &PermissionDeniedError{Subject: subject, Object: object, Action: action}
+}
+
+// contains reports whether the list has s, or the wildcard
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s || v == Wildcard {
+			return true
+		}
+	}
+	return false
+}
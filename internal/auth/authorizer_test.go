@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "policy.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{
+		"rules": [
+			{"subject": "alice", "actions": ["produce", "consume"], "logs": ["*"]},
+			{"subject": "ops", "actions": ["admin"]},
+			{"subject": "*", "actions": ["consume"], "logs": ["public"]}
+		]
+	}`), 0644))
+	p, err := ReadPolicy(file)
+	require.NoError(t, err)
+	a, err := New(p)
+	require.NoError(t, err)
+
+	for _, allowed := range [][3]string{
+		{"alice", "default", ActionProduce},
+		{"alice", "default", ActionConsume},
+		{"ops", "default", ActionAdmin},
+		{"bob", "public", ActionConsume},
+		{"", "public", ActionConsume},
+	} {
+		require.NoError(t, a.Authorize(allowed[0], allowed[1], allowed[2]), "%v", allowed)
+	}
+	for _, denied := range [][3]string{
+		{"alice", "default", ActionAdmin},
+		{"ops", "default", ActionConsume},
+		{"bob", "default", ActionConsume},
+		{"", "public", ActionProduce},
+	} {
+		err := a.Authorize(denied[0], denied[1], denied[2])
+		require.True(t, errors.Is(err, ErrPermissionDenied), "%v", denied)
+		var pde *PermissionDeniedError
+		require.True(t, errors.As(err, &pde))
+		require.Equal(t, denied[0], pde.Subject)
+	}
+}
+
+func TestNewInvalidPolicy(t *testing.T) {
+	for _, p := range []Policy{
+		{Rules: []Rule{{Actions: []string{ActionProduce}}}},
+		{Rules: []Rule{{Subject: "alice"}}},
+		{Rules: []Rule{{Subject: "alice", Actions: []string{"delete"}}}},
+	} {
+		_, err := New(p)
+		require.Error(t, err)
+	}
+}
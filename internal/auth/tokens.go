@@ -0,0 +1,113 @@
+package auth
+
+// Bearer tokens, for the clients that can't do mutual TLS. The token file maps the SHA-256 of every
+// token, in hex, to the subject the token authenticates, the tokens themselves aren't stored:
+//
+//	{
+//	  "tokens": [
+//	    {"subject": "alice", "sha256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+//	  ]
+//	}
+//
+// HashToken, or `printf %s "$TOKEN" | sha256sum`, gives the hash of a token. The file is read again
+// when it changes, a file that fails to load keeps the tokens loaded last.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrUnauthenticated is returned by Authenticate for tokens the store doesn't know
+var ErrUnauthenticated = errors.New("auth: invalid token")
+
+// Token is an entry of the token file
+type Token struct {
+	Subject string `json:"subject"`
+	SHA256  string `json:"sha256"`
+}
+
+// TokenStore authenticates the tokens of a token file
+type TokenStore struct {
+	file string
+
+	mu     sync.Mutex
+	tokens map[[sha256.Size]byte]string
+	mtime  time.Time
+}
+
+// NewTokenStore loads the token file
+func NewTokenStore(file string) (*TokenStore, error) {
+	s := &TokenStore{file: file}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// HashToken returns the hash of the token as it goes in the token file
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns the subject of the token
+func (s *TokenStore) Authenticate(token string) (string, error) {
+	sum := sha256.Sum256([]byte(token))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fi, err := os.Stat(s.file); err == nil && !fi.ModTime().Equal(s.mtime) {
+		s.reload()
+	}
+	subject, ok := s.tokens[sum]
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return subject, nil
+}
+
+// Reload reads the token file again
+func (s *TokenStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reload()
+}
+
+// reload reads the token file, s.mu must be held
+func (s *TokenStore) reload() error {
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	var f struct {
+		Tokens []Token `json:"tokens"`
+	}
+	if err = json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("auth: invalid token file %q: %v", s.file, err)
+	}
+	tokens := make(map[[sha256.Size]byte]string, len(f.Tokens))
+	for i, t := range f.Tokens {
+		b, err := hex.DecodeString(t.SHA256)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("auth: token %d of %q isn't a hex SHA-256", i, s.file)
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], b)
+		if t.Subject == "" {
+			return fmt.Errorf("auth: token %d of %q has no subject", i, s.file)
+		}
+		tokens[sum] = t.Subject
+	}
+	s.tokens, s.mtime = tokens, fi.ModTime()
+	return nil
+}
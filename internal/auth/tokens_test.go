@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "tokens.json")
+	write := func(subject, token string, mtime time.Time) {
+		body := fmt.Sprintf(`{"tokens": [{"subject": %q, "sha256": %q}]}`, subject, HashToken(token))
+		require.NoError(t, ioutil.WriteFile(file, []byte(body), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+	write("alice", "secret", time.Now())
+
+	s, err := NewTokenStore(file)
+	require.NoError(t, err)
+	subject, err := s.Authenticate("secret")
+	require.NoError(t, err)
+	require.Equal(t, "alice", subject)
+	_, err = s.Authenticate("wrong")
+	require.True(t, errors.Is(err, ErrUnauthenticated))
+
+	// the file is read again when it changes
+	write("bob", "rotated", time.Now().Add(time.Minute))
+	subject, err = s.Authenticate("rotated")
+	require.NoError(t, err)
+	require.Equal(t, "bob", subject)
+	_, err = s.Authenticate("secret")
+	require.Error(t, err)
+
+	// a broken file keeps the tokens loaded last
+	require.NoError(t, ioutil.WriteFile(file, []byte("{"), 0600))
+	later := time.Now().Add(2 * time.Minute)
+	require.NoError(t, os.Chtimes(file, later, later))
+	_, err = s.Authenticate("rotated")
+	require.NoError(t, err)
+	require.Error(t, s.Reload())
+}
+
+func TestTokenStoreInvalidHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "tokens.json")
+	sum := HashToken("secret")
+	for _, hash := range []string{sum + "00", sum[:62], sum[:63], "zz" + sum[2:]} {
+		body := fmt.Sprintf(`{"tokens": [{"subject": "alice", "sha256": %q}]}`, hash)
+		require.NoError(t, ioutil.WriteFile(file, []byte(body), 0600))
+		_, err := NewTokenStore(file)
+		require.Error(t, err, hash)
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+// TLS configuration of the servers and their clients.
+// The certificate and key, and the CA bundle the server verifies client certificates with, are
+// read again whenever their files change, so certificates can be rotated without restarting. The
+// files are checked at every handshake, a handshake that finds them half written keeps using the
+// certificates it had.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig says which files to build a tls.Config from.
+// A server with a CAFile requires client certificates signed by one of its CAs (mutual TLS), a
+// client uses its CAFile to verify the server, and its certificate, if it has one, to authenticate.
+type TLSConfig struct {
+	CertFile      string
+	KeyFile       string
+	CAFile        string
+	ServerAddress string
+	Server        bool
+}
+
+// SetupTLSConfig builds the tls.Config described by cfg
+func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	if cfg.Server && cfg.CertFile == "" {
+		return nil, errors.New("config: a server needs a certificate and key")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("config: the certificate and key files go together")
+	}
+	r := &reloader{cfg: cfg}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.Server {
+		tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+		if cfg.CAFile != "" {
+			// the client certificate is verified by verifyClient, against the CAs loaded last
+			tlsConfig.ClientAuth = tls.RequireAnyClientCert
+			tlsConfig.VerifyPeerCertificate = r.verifyClient
+		}
+		return tlsConfig, nil
+	}
+
+	tlsConfig.ServerName = cfg.ServerAddress
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	if cfg.CAFile != "" {
+		_, tlsConfig.RootCAs = r.current()
+	}
+	return tlsConfig, nil
+}
+
+// reloader holds the certificate and CAs last read from the files of cfg
+type reloader struct {
+	cfg TLSConfig
+
+	mu    sync.Mutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+	mtime time.Time
+}
+
+// current returns the certificate and CAs, reading them again first when the files have changed
+func (r *reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if mtime, err := r.modTime(); err == nil && !mtime.Equal(r.mtime) {
+		// a failed reload keeps the old certificates, the files are likely being written
+		r.reload()
+	}
+	return r.cert, r.pool
+}
+
+func (r *reloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+// reload reads the files, r.mu must be held
+func (r *reloader) reload() error {
+	mtime, err := r.modTime()
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		b, err := ioutil.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("config: failed to parse the CA bundle %q", r.cfg.CAFile)
+		}
+	}
+	r.cert, r.pool, r.mtime = cert, pool, mtime
+	return nil
+}
+
+// modTime returns the latest modification time of the files
+func (r *reloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// verifyClient verifies the client's certificate chain against the current CAs
+func (r *reloader) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("config: no client certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
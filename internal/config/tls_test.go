@@ -0,0 +1,177 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCA signs the certificates of the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes a certificate for cn and its key to dir/name.pem and dir/name-key.pem
+func (ca *testCA) issue(t *testing.T, dir, name, cn string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	require.NoError(t, ioutil.WriteFile(file, b, 0600))
+}
+
+// handshake connects a client to a server and returns the common names each side sees
+func handshake(t *testing.T, server, client *tls.Config) (serverSaw, clientSaw string, err error) {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	require.NoError(t, err)
+	defer l.Close()
+
+	saw := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			saw <- ""
+			return
+		}
+		defer conn.Close()
+		tc := conn.(*tls.Conn)
+		if err := tc.Handshake(); err != nil {
+			saw <- ""
+			return
+		}
+		var cn string
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			cn = certs[0].Subject.CommonName
+		}
+		saw <- cn
+		// wait for the client to close, so it can read the server's alerts
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), client)
+	if err == nil {
+		// TLS 1.3 clients only learn their certificate was refused once they read
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, rerr := conn.Read(make([]byte, 1)); rerr != nil {
+			if ne, ok := rerr.(net.Error); !ok || !ne.Timeout() {
+				err = rerr
+			}
+		}
+		clientSaw = conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		conn.Close()
+	}
+	return <-saw, clientSaw, err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	serverCert, serverKey := ca.issue(t, dir, "server", "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", "alice", x509.ExtKeyUsageClientAuth)
+
+	server, err := SetupTLSConfig(TLSConfig{
+		CertFile: serverCert,
+		KeyFile:  serverKey,
+		CAFile:   caFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	client, err := SetupTLSConfig(TLSConfig{
+		CertFile:      clientCert,
+		KeyFile:       clientKey,
+		CAFile:        caFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	serverSaw, clientSaw, err := handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, "alice", serverSaw)
+	require.Equal(t, "server", clientSaw)
+
+	// clients without a certificate are refused
+	anonymous, err := SetupTLSConfig(TLSConfig{CAFile: caFile, ServerAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	_, _, err = handshake(t, server, anonymous)
+	require.Error(t, err)
+
+	// the server picks up a new certificate without being set up again
+	ca.issue(t, dir, "server", "rotated", x509.ExtKeyUsageServerAuth)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(serverCert, later, later))
+	_, clientSaw, err = handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, "rotated", clientSaw)
+}
+
+func TestSetupTLSConfigErrors(t *testing.T) {
+	_, err := SetupTLSConfig(TLSConfig{Server: true})
+	require.Error(t, err)
+	_, err = SetupTLSConfig(TLSConfig{CertFile: "cert.pem"})
+	require.Error(t, err)
+	_, err = SetupTLSConfig(TLSConfig{CertFile: "missing.pem", KeyFile: "missing-key.pem"})
+	require.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package log
+
+// A bounded cache of recently read records. Consumers that follow the log closely tend to read the
+// same recent offsets within moments of each other, the cache saves every one of them but the
+// first a read from the store and a proto.Unmarshal.
+// Records are cached decoded, and copies are handed out so callers can't change what the cache
+// holds. The cache is bounded by the encoded size of the records, the least recently read records
+// are dropped first when it's full.
+
+import (
+	"container/list"
+	"sync"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// CacheStats reports the state of the record cache of a log
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   uint64
+}
+
+type cacheEntry struct {
+	off    uint64
+	record *api.Record
+	size   uint64
+}
+
+type recordCache struct {
+	mu       sync.Mutex
+	maxBytes uint64
+	size     uint64
+	// lru holds the cached entries, the most recently read at the front
+	lru     *list.List
+	entries map[uint64]*list.Element
+
+	hits, misses uint64
+}
+
+func newRecordCache(maxBytes uint64) *recordCache {
+	return &recordCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[uint64]*list.Element),
+	}
+}
+
+func (c *recordCache) enabled() bool {
+	return c.maxBytes > 0
+}
+
+// get returns a copy of the record cached for the offset
+func (c *recordCache) get(off uint64) (*api.Record, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[off]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return proto.Clone(e.Value.(*cacheEntry).record).(*api.Record), true
+}
+
+// put caches a copy of the record read at the offset, records bigger than the whole cache aren't
+// cached
+func (c *recordCache) put(off uint64, record *api.Record) {
+	if !c.enabled() {
+		return
+	}
+	size := uint64(proto.Size(record))
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[off]; ok {
+		return
+	}
+	c.entries[off] = c.lru.PushFront(&cacheEntry{
+		off:    off,
+		record: proto.Clone(record).(*api.Record),
+		size:   size,
+	})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// removeBelow drops the records with an offset lower than lowest, the log calls it after it has
+// been truncated
+func (c *recordCache) removeBelow(lowest uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for off, e := range c.entries {
+		if off < lowest {
+			c.removeElement(e)
+		}
+	}
+}
+
+// purge drops every cached record
+func (c *recordCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[uint64]*list.Element)
+	c.size = 0
+}
+
+func (c *recordCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.off)
+	c.size -= entry.size
+}
+
+func (c *recordCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.lru.Len(),
+		Bytes:   c.size,
+	}
+}
@@ -0,0 +1,87 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRecordCache(t *testing.T) {
+	record := &api.Record{Value: []byte("hello world"), Offset: 1}
+	size := uint64(proto.Size(record))
+	c := newRecordCache(size * 2)
+
+	_, ok := c.get(1)
+	require.False(t, ok)
+	c.put(1, record)
+	got, ok := c.get(1)
+	require.True(t, ok)
+	require.True(t, proto.Equal(record, got))
+
+	// the cache hands out copies
+	got.Value[0] = 'j'
+	got, _ = c.get(1)
+	require.Equal(t, []byte("hello world"), got.Value)
+
+	// the least recently read record is dropped when the cache is full
+	c.put(2, record)
+	_, _ = c.get(1)
+	c.put(3, record)
+	_, ok = c.get(2)
+	require.False(t, ok)
+	_, ok = c.get(1)
+	require.True(t, ok)
+
+	c.removeBelow(3)
+	_, ok = c.get(1)
+	require.False(t, ok)
+
+	stats := c.stats()
+	require.Equal(t, uint64(4), stats.Hits)
+	require.Equal(t, uint64(3), stats.Misses)
+	require.Equal(t, 1, stats.Entries)
+	require.Equal(t, size, stats.Bytes)
+}
+
+func TestLogCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Cache.MaxBytes = 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		read, err := log.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), read.Offset)
+	}
+	stats := log.CacheStats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+
+	// truncated records aren't served from the cache
+	require.NoError(t, log.Truncate(1))
+	_, err = log.Read(0)
+	require.Error(t, err)
+
+	// neither are records from before a reset
+	_, err = log.Read(2)
+	require.NoError(t, err)
+	require.NoError(t, log.Reset())
+	_, err = log.Read(2)
+	require.Error(t, err)
+	require.Equal(t, 0, log.CacheStats().Entries)
+	require.NoError(t, log.Close())
+}
@@ -1,9 +1,39 @@
 package log
 
+import "time"
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// FormatVersion is the on-disk format new segments are written with, zero means the
+		// latest version. Existing segments are always read in the format they were written in.
+		FormatVersion uint8
+		// WideIndex makes new segments store relative offsets in 8 bytes instead of 4. It's only
+		// needed when MaxIndexBytes allows more entries per segment than a uint32 can address,
+		// and requires format version 2.
+		WideIndex bool
+		// MaxOpenSegments limits how many segments have their files open at a time, the least
+		// recently read segments are closed when it's exceeded. Zero means no limit.
+		MaxOpenSegments int
+		// SyncWrites makes appends wait for the record to be synced to disk before returning
+		SyncWrites bool
+		// MaxRecordBytes is the most bytes a record may take in the store, its framing included.
+		// Zero means no limit, a record bigger than MaxStoreBytes then gets a segment of its own.
+		MaxRecordBytes uint64
+	}
+	Cache struct {
+		// MaxBytes is the most bytes of records kept in the cache of recently read records,
+		// zero disables the cache
+		MaxBytes uint64
+	}
+	// Retention is how much of the log ApplyRetention keeps, see retention.go. Zero values keep
+	// everything.
+	Retention struct {
+		// MaxBytes is the most bytes the stores of the segments may take together
+		MaxBytes uint64
+		// MaxAge is how long a segment is kept after its last append
+		MaxAge time.Duration
 	}
 }
@@ -0,0 +1,116 @@
+package log
+
+// Helpers for the context aware methods of the log. sync.RWMutex can't be waited on with a
+// context, so the log is guarded by a readers-writer lock made of channels, whose waits can be
+// given up on without leaving anything behind. Contexts that can't be done, like
+// context.Background(), wait like they would on a sync.RWMutex.
+
+import (
+	"context"
+)
+
+// rwMutex is a readers-writer lock. Writers go through the turnstile while they wait, so a steady
+// stream of readers can't keep them waiting forever.
+type rwMutex struct {
+	// turnstile is held by the writer waiting for the lock
+	turnstile chan struct{}
+	// w is held by the writer, or by the readers together
+	w chan struct{}
+	// readers holds the number of readers, taking it is taking the readers' mutex
+	readers chan int
+}
+
+func newRWMutex() *rwMutex {
+	m := &rwMutex{
+		turnstile: make(chan struct{}, 1),
+		w:         make(chan struct{}, 1),
+		readers:   make(chan int, 1),
+	}
+	m.readers <- 0
+	return m
+}
+
+func (m *rwMutex) Lock()   { m.lock(context.Background()) }
+func (m *rwMutex) RLock()  { m.rlock(context.Background()) }
+func (m *rwMutex) Unlock() { <-m.w }
+func (m *rwMutex) RUnlock() {
+	n := <-m.readers - 1
+	if n == 0 {
+		<-m.w
+	}
+	m.readers <- n
+}
+
+func (m *rwMutex) lock(ctx context.Context) error {
+	select {
+	case m.turnstile <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-m.turnstile }()
+	select {
+	case m.w <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *rwMutex) rlock(ctx context.Context) error {
+	// a waiting writer goes first
+	select {
+	case m.turnstile <- struct{}{}:
+		<-m.turnstile
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	var n int
+	select {
+	case n = <-m.readers:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// the first reader takes the lock for all of them, the others wait behind it for the readers
+	if n == 0 {
+		select {
+		case m.w <- struct{}{}:
+		case <-ctx.Done():
+			m.readers <- n
+			return ctx.Err()
+		}
+	}
+	m.readers <- n + 1
+	return nil
+}
+
+func lockContext(ctx context.Context, mu *rwMutex) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return mu.lock(ctx)
+}
+
+func rlockContext(ctx context.Context, mu *rwMutex) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return mu.rlock(ctx)
+}
+
+// syncSegment starts syncing the segment's files to disk, l.mu must be held. The segment is held
+// open by the pool until the sync has finished, and Truncate and Close wait for it, so the
+// segment's files stay around for the sync even when the caller has stopped waiting for it.
+func (l *Log) syncSegment(s *segment) <-chan error {
+	done := make(chan error, 1)
+	if err := l.pool.acquire(s); err != nil {
+		done <- err
+		return done
+	}
+	l.syncs.Add(1)
+	go func() {
+		defer l.syncs.Done()
+		defer l.pool.release(s)
+		done <- s.Sync()
+	}()
+	return done
+}
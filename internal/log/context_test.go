@@ -0,0 +1,115 @@
+package log
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-context-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.SyncWrites = true
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	record := &api.Record{Value: []byte("hello world")}
+	off, err := log.AppendContext(context.Background(), record)
+	require.NoError(t, err)
+	read, err := log.ReadContext(context.Background(), off)
+	require.NoError(t, err)
+	require.Equal(t, record.Value, read.Value)
+
+	// a context that is already done doesn't get to do any work
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = log.AppendContext(ctx, record)
+	require.Equal(t, context.Canceled, err)
+	_, err = log.ReadContext(ctx, off)
+	require.Equal(t, context.Canceled, err)
+
+	// waiting for the lock gives up at the deadline
+	log.mu.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = log.ReadContext(ctx, off)
+	require.Equal(t, context.DeadlineExceeded, err)
+	_, err = log.AppendContext(ctx, record)
+	require.Equal(t, context.DeadlineExceeded, err)
+	log.mu.Unlock()
+
+	// the abandoned lock attempts don't keep the lock
+	off, err = log.Append(record)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+func TestRWMutex(t *testing.T) {
+	mu := newRWMutex()
+	mu.RLock()
+	mu.RLock()
+
+	// a waiting writer keeps new readers out until it's had the lock
+	locked := make(chan struct{})
+	go func() {
+		mu.Lock()
+		close(locked)
+		mu.Unlock()
+	}()
+	require.Eventually(t, func() bool { return len(mu.turnstile) == 1 }, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, rlockContext(ctx, mu))
+
+	mu.RUnlock()
+	mu.RUnlock()
+	<-locked
+
+	// the abandoned waits left nothing behind
+	mu.Lock()
+	mu.Unlock()
+	mu.RLock()
+	mu.RUnlock()
+}
+
+func TestSyncWritesTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-context-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.SyncWrites = true
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// every append rolls the segment, which is truncated away while its sync may still be running
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_, err := log.Append(&api.Record{Value: make([]byte, 20)})
+			require.NoError(t, err)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		off, err := log.HighestOffset()
+		require.NoError(t, err)
+		require.NoError(t, log.Truncate(off))
+	}
+}
@@ -0,0 +1,71 @@
+package log
+
+// Errors returned by the log. The sentinels are meant to be checked with errors.Is, the typed
+// errors carry the details and can be pulled out with errors.As, each of them matches its
+// sentinel with errors.Is:
+//
+//	var oor *OffsetOutOfRangeError
+//	if errors.As(err, &oor) { ... oor.Offset ... }
+//	if errors.Is(err, ErrOffsetOutOfRange) { ... }
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrOffsetOutOfRange is returned when reading an offset the log doesn't have
+	ErrOffsetOutOfRange = errors.New("log: offset out of range")
+	// ErrLogClosed is returned by operations on a log that has been closed
+	ErrLogClosed = errors.New("log: log is closed")
+	// ErrRecordTooLarge is returned when appending a record that can't fit in a segment
+	ErrRecordTooLarge = errors.New("log: record too large")
+	// ErrCorrupt is returned when the data on disk can't be decoded
+	ErrCorrupt = errors.New("log: corrupt data")
+)
+
+// OffsetOutOfRangeError reports the offset that was out of range
+type OffsetOutOfRangeError struct {
+	Offset uint64
+}
+
+func (e *OffsetOutOfRangeError) Error() string {
+	return fmt.Sprintf("log: offset out of range: %d", e.Offset)
+}
+
+func (e *OffsetOutOfRangeError) Is(target error) bool {
+	return target == ErrOffsetOutOfRange
+}
+
+// RecordTooLargeError reports the size of a record bigger than the log's MaxRecordBytes
+type RecordTooLargeError struct {
+	Size uint64
+	Max  uint64
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("log: record of %d bytes is larger than the max of %d", e.Size, e.Max)
+}
+
+func (e *RecordTooLargeError) Is(target error) bool {
+	return target == ErrRecordTooLarge
+}
+
+// CorruptError reports where the corrupt data is, Err is what went wrong decoding it
+type CorruptError struct {
+	Path string
+	Pos  uint64
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("log: corrupt data in %s at position %d: %v", e.Path, e.Pos, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
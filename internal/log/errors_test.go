@@ -0,0 +1,97 @@
+package log
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-errors-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.MaxRecordBytes = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	record := &api.Record{Value: []byte("hello world")}
+	off, err := log.Append(record)
+	require.NoError(t, err)
+
+	// past the end of the log, and past the end of the index of the active segment
+	for _, o := range []uint64{off + 1, off + 100} {
+		_, err = log.Read(o)
+		require.True(t, errors.Is(err, ErrOffsetOutOfRange))
+		var oor *OffsetOutOfRangeError
+		require.True(t, errors.As(err, &oor))
+		require.Equal(t, o, oor.Offset)
+	}
+
+	// 62 bytes of value, 2 of offset and 2 of framing
+	_, err = log.Append(&api.Record{Value: make([]byte, 60)})
+	require.True(t, errors.Is(err, ErrRecordTooLarge))
+	var tooLarge *RecordTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, uint64(66), tooLarge.Size)
+	require.Equal(t, uint64(64), tooLarge.Max)
+
+	require.NoError(t, log.Close())
+	_, err = log.Append(record)
+	require.Equal(t, ErrLogClosed, err)
+	_, err = log.Read(off)
+	require.Equal(t, ErrLogClosed, err)
+	require.Equal(t, ErrLogClosed, log.Truncate(0))
+
+	// cut the record short, the index still points at it
+	storeFile := path.Join(dir, "0.store")
+	fi, err := os.Stat(storeFile)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(storeFile, fi.Size()-2))
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Read(off)
+	require.True(t, errors.Is(err, ErrCorrupt))
+	var corrupt *CorruptError
+	require.True(t, errors.As(err, &corrupt))
+	require.Equal(t, storeFile, corrupt.Path)
+
+	// cut the whole record off
+	require.NoError(t, log.Close())
+	require.NoError(t, os.Truncate(storeFile, headerWidth))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Read(off)
+	require.True(t, errors.Is(err, ErrCorrupt))
+}
+
+func TestLargeRecordRolls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-errors-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// without MaxRecordBytes a record bigger than a store gets a segment to itself
+	value := make([]byte, 100)
+	off, err := log.Append(&api.Record{Value: value})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(log.segments))
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, value, read.Value)
+}
@@ -0,0 +1,21 @@
+package log
+
+// Health of the log, for the readiness checks of the server. Once the store has failed to write,
+// its buffered writer fails every write after it the same way, so a log whose append failed stays
+// unwritable until it is opened again.
+
+import "fmt"
+
+// Writable returns nil when records can be appended to the log, ErrLogClosed once it's closed, and
+// the error of the failed append, or roll, when one has failed
+func (l *Log) Writable() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	if l.writeErr != nil {
+		return fmt.Errorf("log: not writable since an append failed: %w", l.writeErr)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-health-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 16
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.NoError(t, log.Writable())
+
+	// a record bigger than the store's buffer is written to the file straight away
+	require.NoError(t, log.activeSegment.store.File.Close())
+	_, err = log.Append(&api.Record{Value: make([]byte, 8192)})
+	require.Error(t, err)
+	require.Error(t, log.Writable())
+	require.NotErrorIs(t, log.Writable(), ErrLogClosed)
+
+	log.Close()
+	require.ErrorIs(t, log.Writable(), ErrLogClosed)
+}
@@ -0,0 +1,95 @@
+package log
+
+// Hooks let programs embedding the log react to changes of its segments, to upload a segment once
+// it's sealed or to tell an indexer about removed segments for example.
+// Hooks are called after the log's lock has been released, on the goroutine of the call that
+// caused the event, so a hook may call back into the log. A slow hook slows that call down, hooks
+// with real work to do should hand it over to a goroutine of their own.
+
+import (
+	"sync"
+)
+
+// SegmentEventType is the kind of change a SegmentEvent reports
+type SegmentEventType int
+
+const (
+	// SegmentCreated is sent when a new active segment is created by a roll or a Reset
+	SegmentCreated SegmentEventType = iota
+	// SegmentSealed is sent when the active segment is full and no more records go into it
+	SegmentSealed
+	// SegmentRemoved is sent when Truncate removed the segment's files
+	SegmentRemoved
+	// LogReset is sent when Reset removed every segment of the log
+	LogReset
+)
+
+func (t SegmentEventType) String() string {
+	switch t {
+	case SegmentCreated:
+		return "created"
+	case SegmentSealed:
+		return "sealed"
+	case SegmentRemoved:
+		return "removed"
+	case LogReset:
+		return "reset"
+	}
+	return "unknown"
+}
+
+// SegmentEvent describes a change to a segment. The offsets and paths are those of the segment at
+// the time of the event, NextOffset is the offset the next record of the segment would have had.
+// LogReset events only have the Type set.
+type SegmentEvent struct {
+	Type       SegmentEventType
+	BaseOffset uint64
+	NextOffset uint64
+	StorePath  string
+	IndexPath  string
+}
+
+// Hook is called with every segment event of the log it is added to
+type Hook func(SegmentEvent)
+
+type hooks struct {
+	mu    sync.Mutex
+	hooks []Hook
+}
+
+func (h *hooks) add(hook Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+// fire calls every hook with the events, in the order the events happened
+func (h *hooks) fire(events []SegmentEvent) {
+	if len(events) == 0 {
+		return
+	}
+	h.mu.Lock()
+	hs := make([]Hook, len(h.hooks))
+	copy(hs, h.hooks)
+	h.mu.Unlock()
+	for _, e := range events {
+		for _, hook := range hs {
+			hook(e)
+		}
+	}
+}
+
+func newSegmentEvent(t SegmentEventType, s *segment) SegmentEvent {
+	return SegmentEvent{
+		Type:       t,
+		BaseOffset: s.baseOffset,
+		NextOffset: s.nextOffset,
+		StorePath:  s.storePath(),
+		IndexPath:  s.indexPath(),
+	}
+}
+
+// AddHook registers a hook to be called with the segment events of the log
+func (l *Log) AddHook(hook Hook) {
+	l.hooks.add(hook)
+}
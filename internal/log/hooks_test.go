@@ -0,0 +1,63 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	var events []SegmentEvent
+	log.AddHook(func(e SegmentEvent) {
+		// hooks run outside the lock, so calling back into the log doesn't deadlock
+		_, err := log.HighestOffset()
+		require.NoError(t, err)
+		events = append(events, e)
+	})
+
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []SegmentEvent{{
+		Type:       SegmentSealed,
+		BaseOffset: 0,
+		NextOffset: 2,
+		StorePath:  path.Join(dir, "0.store"),
+		IndexPath:  path.Join(dir, "0.index"),
+	}, {
+		Type:       SegmentCreated,
+		BaseOffset: 2,
+		NextOffset: 2,
+		StorePath:  path.Join(dir, "2.store"),
+		IndexPath:  path.Join(dir, "2.index"),
+	}}, events)
+
+	events = nil
+	require.NoError(t, log.Truncate(1))
+	require.Equal(t, 1, len(events))
+	require.Equal(t, SegmentRemoved, events[0].Type)
+	require.Equal(t, uint64(0), events[0].BaseOffset)
+
+	events = nil
+	require.NoError(t, log.Reset())
+	require.Equal(t, 2, len(events))
+	require.Equal(t, LogReset, events[0].Type)
+	require.Equal(t, SegmentCreated, events[1].Type)
+	require.Equal(t, uint64(0), events[1].BaseOffset)
+	require.NoError(t, log.Close())
+}
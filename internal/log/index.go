@@ -2,7 +2,9 @@ package log
 
 // Implements the methdos and data structures required for reading memory mapped files, in this case index file
 import (
+	"fmt"
 	"io"
+	"math"
 	"os"
 
 	//"github.com/tysontate/gommap" // allows working with memory mapped files
@@ -20,6 +22,11 @@ var (
 	offWidth uint64 = 4
 	posWidth uint64 = 8
 	entWidth        = offWidth + posWidth
+
+	// a wide index stores the relative offset with 8 bytes instead of 4, for segments configured
+	// with more entries than a uint32 can address
+	wideOffWidth uint64 = 8
+	wideEntWidth        = wideOffWidth + posWidth
 )
 
 // structure has fields to
@@ -27,18 +34,26 @@ var (
 // to refer to a memory maped files, and lastly a size field
 //which helps to write the next entry to the index file
 type index struct {
-	file *os.File
-	mmap gommap.MMap
-	size uint64
+	file     *os.File
+	mmap     gommap.MMap
+	size     uint64
+	offWidth uint64
+	entWidth uint64
 }
 
 // FUnction creates a pointer to an index struct
 // it assigns the size field with the size of opened file f
 // it then truncates the file to maximum index size by using os.Truncate
 // then finally memory maps the files using gommap.Map, and assigns the returned gommap.Map instance to idx.mmp field
+// c.Segment.WideIndex picks the width of the relative offsets
 func newIndex(f *os.File, c Config) (*index, error) {
 	idx := &index{
-		file: f,
+		file:     f,
+		offWidth: offWidth,
+		entWidth: entWidth,
+	}
+	if c.Segment.WideIndex {
+		idx.offWidth, idx.entWidth = wideOffWidth, wideEntWidth
 	}
 
 	fi, err := os.Stat(f.Name())
@@ -57,7 +72,7 @@ func newIndex(f *os.File, c Config) (*index, error) {
 		gommap.PROT_READ|gommap.PROT_WRITE,
 		gommap.MAP_SHARED,
 	); err != nil {
-		return nil, nil
+		return nil, err
 	}
 	return idx, nil
 
@@ -75,6 +90,7 @@ can make the graceful shutdown, and restart possible eventually.
 
 // Function closes the file stored at i.file
 // it first makes sure the memory mapped file at idx.mmap is synced to the actual file or not
+// it then unmaps it, a closed index is reopened by the segment pool with a mapping of its own
 // it then truncates the persisted file to the amount of data that's actually in it
 func (i *index) Close() error {
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
@@ -83,6 +99,10 @@ func (i *index) Close() error {
 	if err := i.file.Sync(); err != nil {
 		return err
 	}
+	if err := i.mmap.UnsafeUnmap(); err != nil {
+		return err
+	}
+	i.mmap = nil
 	if err := i.file.Truncate(int64(i.size)); err != nil {
 		return err
 	}
@@ -93,21 +113,27 @@ func (i *index) Close() error {
 // takes an offset and returns associated record's position in store
 // Offset values are usually 0,1. So, position would be computed
 // as offset value * entWidth.
-func (i *index) Read(in int64) (off uint32, pos uint64, err error) {
+func (i *index) Read(in int64) (off uint64, pos uint64, err error) {
 	if i.size == 0 {
 		return 0, 0, io.EOF
 	}
 	if in == -1 {
-		off = uint32((i.size / entWidth) - 1)
+		off = (i.size / i.entWidth) - 1
+	} else if in < 0 {
+		return 0, 0, io.EOF
 	} else {
-		off = uint32(in)
+		off = uint64(in)
 	}
-	pos = uint64(off) * entWidth
-	if i.size < pos+entWidth {
+	if off >= i.size/i.entWidth {
 		return 0, 0, io.EOF
 	}
-	off = enc.Uint32(i.mmap[pos : pos+offWidth])
-	pos = enc.Uint64(i.mmap[pos+offWidth : pos+entWidth])
+	pos = off * i.entWidth
+	if i.offWidth == wideOffWidth {
+		off = enc.Uint64(i.mmap[pos : pos+i.offWidth])
+	} else {
+		off = uint64(enc.Uint32(i.mmap[pos : pos+i.offWidth]))
+	}
+	pos = enc.Uint64(i.mmap[pos+i.offWidth : pos+i.entWidth])
 	return off, pos, nil
 }
 
@@ -115,16 +141,30 @@ func (i *index) Read(in int64) (off uint32, pos uint64, err error) {
 // first validate if we have space to write, if we find there is space, we
 // encode the offset and position values to ultimately use them to write to the memory mapped files
 
-func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth {
+// A relative offset that doesn't fit in the width of the index is an error, it would otherwise
+// wrap around and point reads at the wrong record.
+func (i *index) Write(off uint64, pos uint64) error {
+	if i.offWidth == offWidth && off > math.MaxUint32 {
+		return fmt.Errorf("log: relative offset %d does not fit in a narrow index", off)
+	}
+	if uint64(len(i.mmap)) < i.size+i.entWidth {
 		return io.EOF
 	}
-	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
-	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
-	i.size += uint64(entWidth)
+	if i.offWidth == wideOffWidth {
+		enc.PutUint64(i.mmap[i.size:i.size+i.offWidth], off)
+	} else {
+		enc.PutUint32(i.mmap[i.size:i.size+i.offWidth], uint32(off))
+	}
+	enc.PutUint64(i.mmap[i.size+i.offWidth:i.size+i.entWidth], pos)
+	i.size += i.entWidth
 	return nil
 }
 
+// Sync syncs the memory mapped entries to the file
+func (i *index) Sync() error {
+	return i.mmap.Sync(gommap.MS_SYNC)
+}
+
 func (i *index) Name() string {
 	return i.file.Name()
 }
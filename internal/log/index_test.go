@@ -3,6 +3,7 @@ package log
 import (
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"testing"
 
@@ -22,7 +23,7 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, f.Name(), idx.Name())
 
 	entries := []struct {
-		Off uint32
+		Off uint64
 		Pos uint64
 	}{
 		{Off: 0, Pos: 0},
@@ -45,7 +46,31 @@ func TestIndex(t *testing.T) {
 	require.NoError(t, err)
 	off, pos, err := idx.Read(-1)
 	require.NoError(t, err)
-	require.Equal(t, uint32(1), off)
+	require.Equal(t, uint64(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 
+	// a narrow index refuses relative offsets that would wrap around
+	err = idx.Write(math.MaxUint32+1, 20)
+	require.Error(t, err)
+	_ = idx.Close()
+}
+
+func TestWideIndex(t *testing.T) {
+	f, err := ioutil.TempFile(os.TempDir(), "wide_index_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.WideIndex = true
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+
+	var want uint64 = math.MaxUint32 + 1
+	require.NoError(t, idx.Write(want, 10))
+	require.Equal(t, wideEntWidth, idx.size)
+	off, pos, err := idx.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, want, off)
+	require.Equal(t, uint64(10), pos)
+	_ = idx.Close()
 }
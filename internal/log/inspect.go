@@ -0,0 +1,364 @@
+package log
+
+// Offline inspection of a log directory, for finding out what is wrong with one. Nothing here
+// writes to the directory: the files are opened read only and the index is read from the file
+// instead of being memory mapped, so a directory can be inspected while a log has it open, though
+// it may be caught halfway through an append.
+// Only the segment files are looked at, the manifest is compared with them, the files of the
+// standby segment and of an interrupted migration are left alone.
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// LogInfo describes a log directory, as found by Inspect
+type LogInfo struct {
+	Dir string
+	// HasManifest is false for logs created before manifests were written
+	HasManifest   bool
+	FormatVersion int
+	Segments      []SegmentInfo
+
+	// problems are those found while listing the segments
+	problems []Problem
+}
+
+// SegmentInfo describes a segment of a log directory
+type SegmentInfo struct {
+	BaseOffset uint64
+	// NextOffset is the offset after the last entry of the index
+	NextOffset    uint64
+	StorePath     string
+	IndexPath     string
+	StoreBytes    int64
+	IndexBytes    int64
+	FormatVersion uint8
+	WideIndex     bool
+	// Entries is the number of entries in the index. An index that is open, or wasn't closed, is
+	// as big as MaxIndexBytes, the unused entries at its end are counted by UnusedEntries.
+	Entries       uint64
+	UnusedEntries uint64
+	InManifest    bool
+	// Active is set for the segment the log appends to by Log.Segments, Inspect doesn't know it
+	Active bool
+
+	// open is set for the segments of an open log by Log.Verify, their unused entries aren't a
+	// problem. Check reads no further than Entries and StoreBytes of them, what was appended since
+	// they were listed, and the segments removed since, aren't checked.
+	open bool
+}
+
+// IndexEntry is an entry of the index of a segment
+type IndexEntry struct {
+	Offset    uint64
+	RelOffset uint64
+	Pos       uint64
+}
+
+// Problem is an inconsistency found by Check
+type Problem struct {
+	BaseOffset uint64
+	Message    string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("segment %d: %s", p.BaseOffset, p.Message)
+}
+
+// Inspect lists the segments of the log in dir
+func Inspect(dir string) (*LogInfo, error) {
+	info := &LogInfo{Dir: dir}
+	m, hasManifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	inManifest := make(map[uint64]bool)
+	if hasManifest {
+		info.HasManifest, info.FormatVersion = true, m.FormatVersion
+		for _, base := range m.Segments {
+			inManifest[base] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[uint64]map[string]bool)
+	for _, file := range files {
+		ext := path.Ext(file.Name())
+		base, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ext), 10, 0)
+		if err != nil || file.IsDir() || (ext != ".store" && ext != ".index") {
+			continue
+		}
+		if found[base] == nil {
+			found[base] = make(map[string]bool)
+		}
+		found[base][ext] = true
+	}
+
+	for base, exts := range found {
+		if !exts[".store"] || !exts[".index"] {
+			info.problems = append(info.problems, Problem{base, "store or index file missing"})
+			continue
+		}
+		s, err := inspectSegment(dir, base)
+		if err != nil {
+			info.problems = append(info.problems, Problem{base, err.Error()})
+			continue
+		}
+		s.InManifest = inManifest[base]
+		info.Segments = append(info.Segments, s)
+	}
+	sort.Slice(info.Segments, func(i, j int) bool {
+		return info.Segments[i].BaseOffset < info.Segments[j].BaseOffset
+	})
+	if hasManifest {
+		for _, base := range m.Segments {
+			if found[base] == nil {
+				info.problems = append(info.problems, Problem{base, "in the manifest but not on disk"})
+			}
+		}
+	}
+	return info, nil
+}
+
+func inspectSegment(dir string, base uint64) (SegmentInfo, error) {
+	s := SegmentInfo{
+		BaseOffset: base,
+		StorePath:  path.Join(dir, fmt.Sprintf("%d.store", base)),
+		IndexPath:  path.Join(dir, fmt.Sprintf("%d.index", base)),
+	}
+	st, err := openStoreReadOnly(s.StorePath)
+	if err != nil {
+		return s, err
+	}
+	defer st.Close()
+	s.StoreBytes, s.FormatVersion, s.WideIndex = int64(st.size), st.version, st.wideIndex()
+
+	fi, err := os.Stat(s.IndexPath)
+	if err != nil {
+		return s, err
+	}
+	s.IndexBytes = fi.Size()
+	entries, unused, err := s.readIndex()
+	if err != nil {
+		return s, err
+	}
+	s.Entries, s.UnusedEntries = uint64(len(entries)), unused
+	s.NextOffset = base + s.Entries
+	return s, nil
+}
+
+// openStoreReadOnly opens a store file for reading only, unlike newStore it never writes a header
+func openStoreReadOnly(name string) (*store, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &store{File: f, size: uint64(fi.Size()), buf: bufio.NewWriter(f)}
+	if s.version, s.flags, err = readHeader(f, s.size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// readIndex returns the entries of the index, and the number of unused entries at its end
+func (s SegmentInfo) readIndex() ([]IndexEntry, uint64, error) {
+	b, err := ioutil.ReadFile(s.IndexPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	offW, entW := offWidth, entWidth
+	if s.WideIndex {
+		offW, entW = wideOffWidth, wideEntWidth
+	}
+	n := uint64(len(b)) / entW
+	entries := make([]IndexEntry, 0, n)
+	for i := uint64(0); i < n; i++ {
+		e := b[i*entW : (i+1)*entW]
+		var rel uint64
+		if s.WideIndex {
+			rel = enc.Uint64(e[:offW])
+		} else {
+			rel = uint64(enc.Uint32(e[:offW]))
+		}
+		entries = append(entries, IndexEntry{
+			Offset:    s.BaseOffset + rel,
+			RelOffset: rel,
+			Pos:       enc.Uint64(e[offW:]),
+		})
+	}
+	// the unused space at the end of an index is zeroed. A zero entry is only valid as the first
+	// entry of a version 1 store with records in it, its first record is at position 0.
+	unused := uint64(0)
+	for len(entries) > 0 {
+		last := len(entries) - 1
+		if entries[last].RelOffset != 0 || entries[last].Pos != 0 {
+			break
+		}
+		if last == 0 && s.FormatVersion == formatV1 && s.StoreBytes > 0 {
+			break
+		}
+		entries = entries[:last]
+		unused++
+	}
+	return entries, unused, nil
+}
+
+// IndexEntries returns the entries of the segment's index, without the unused ones
+func (s SegmentInfo) IndexEntries() ([]IndexEntry, error) {
+	entries, _, err := s.readIndex()
+	return entries, err
+}
+
+// Records calls fn with every entry of the index and the record it points at in the store, until
+// fn returns an error. Records that can't be read are reported by Check, Records stops at them.
+func (s SegmentInfo) Records(fn func(IndexEntry, *api.Record) error) error {
+	entries, err := s.IndexEntries()
+	if err != nil {
+		return err
+	}
+	st, err := openStoreReadOnly(s.StorePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	for _, e := range entries {
+		record, _, err := readRecord(st, e.Pos)
+		if err != nil {
+			return err
+		}
+		if err = fn(e, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRecord(st *store, pos uint64) (*api.Record, uint64, error) {
+	p, next, err := st.readEntry(pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	record := &api.Record{}
+	if err = proto.Unmarshal(p, record); err != nil {
+		return nil, 0, &CorruptError{Path: st.Name(), Pos: pos, Err: err}
+	}
+	return record, next, nil
+}
+
+// Check looks for inconsistencies between the index and the store of the segment: entries out of
+// order, entries that don't point at a record, records with the wrong offset, and bytes in the
+// store no entry points at
+func (s SegmentInfo) Check() ([]Problem, error) {
+	var problems []Problem
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{s.BaseOffset, fmt.Sprintf(format, args...)})
+	}
+
+	entries, unused, err := s.readIndex()
+	if s.open && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.open && uint64(len(entries)) > s.Entries {
+		entries = entries[:s.Entries]
+	}
+	if unused > 0 && !s.open {
+		report("index has %d unused entries at its end, the log is open or wasn't closed", unused)
+	}
+	entW := entWidth
+	if s.WideIndex {
+		entW = wideEntWidth
+	}
+	// an index that wasn't closed has the size of MaxIndexBytes, it isn't a whole number of entries
+	if rest := uint64(s.IndexBytes) % entW; rest != 0 && unused == 0 {
+		report("index ends with a partial entry of %d bytes", rest)
+	}
+	if s.FormatVersion > formatVersion {
+		report("store has unsupported format version %d", s.FormatVersion)
+		return problems, nil
+	}
+
+	st, err := openStoreReadOnly(s.StorePath)
+	if s.open && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	if s.open && uint64(s.StoreBytes) < st.size {
+		st.size = uint64(s.StoreBytes)
+	}
+	next := uint64(0)
+	if st.version >= formatV2 {
+		next = headerWidth
+	}
+	for i, e := range entries {
+		if e.RelOffset != uint64(i) {
+			report("entry %d has relative offset %d", i, e.RelOffset)
+		}
+		if e.Pos != next {
+			report("entry %d points at position %d, the previous record ends at %d", i, e.Pos, next)
+		}
+		record, end, err := readRecord(st, e.Pos)
+		if err != nil {
+			report("entry %d: %v", i, err)
+			continue
+		}
+		if record.Offset != e.Offset {
+			report("record at offset %d has offset %d", e.Offset, record.Offset)
+		}
+		next = end
+	}
+	if next < st.size {
+		report("store has %d bytes after the last indexed record", st.size-next)
+	}
+	return problems, nil
+}
+
+// Check returns the problems found while listing the segments, the problems of every segment, and
+// the gaps between the segments and their differences with the manifest
+func (l *LogInfo) Check() ([]Problem, error) {
+	problems := append([]Problem(nil), l.problems...)
+	if !l.HasManifest {
+		problems = append(problems, Problem{Message: "no manifest"})
+	}
+	for i, s := range l.Segments {
+		if l.HasManifest && !s.InManifest {
+			problems = append(problems, Problem{s.BaseOffset, "on disk but not in the manifest"})
+		}
+		if i+1 < len(l.Segments) && s.NextOffset != l.Segments[i+1].BaseOffset {
+			problems = append(problems, Problem{s.BaseOffset, fmt.Sprintf(
+				"ends at offset %d, the next segment starts at %d",
+				s.NextOffset, l.Segments[i+1].BaseOffset,
+			)})
+		}
+		p, err := s.Check()
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p...)
+	}
+	return problems, nil
+}
@@ -0,0 +1,82 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// a log that is open can be inspected, the unused end of the active index is reported
+	info, err := Inspect(dir)
+	require.NoError(t, err)
+	last := info.Segments[len(info.Segments)-1]
+	require.NotZero(t, last.UnusedEntries)
+	require.NoError(t, log.Close())
+
+	info, err = Inspect(dir)
+	require.NoError(t, err)
+	require.True(t, info.HasManifest)
+	require.True(t, len(info.Segments) > 1)
+	require.Equal(t, uint64(0), info.Segments[0].BaseOffset)
+	require.Equal(t, uint64(10), info.Segments[len(info.Segments)-1].NextOffset)
+	problems, err := info.Check()
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	var offsets []uint64
+	for _, s := range info.Segments {
+		require.True(t, s.InManifest)
+		require.NoError(t, s.Records(func(e IndexEntry, r *api.Record) error {
+			require.Equal(t, e.Offset, r.Offset)
+			require.Equal(t, []byte("hello world"), r.Value)
+			offsets = append(offsets, r.Offset)
+			return nil
+		}))
+	}
+	require.Len(t, offsets, 10)
+
+	// bytes no entry points at, and a segment the manifest doesn't know
+	first := info.Segments[0]
+	f, err := os.OpenFile(first.StorePath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("garbage"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	for _, ext := range []string{".store", ".index"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, "100"+ext), nil, 0644))
+	}
+	// the files of the standby segment aren't segment files
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "standby.store"), []byte("x"), 0644))
+
+	info, err = Inspect(dir)
+	require.NoError(t, err)
+	problems, err = info.Check()
+	require.NoError(t, err)
+	var messages []string
+	for _, p := range problems {
+		messages = append(messages, p.String())
+	}
+	all := strings.Join(messages, "\n")
+	require.Contains(t, all, "segment 0: store has 7 bytes after the last indexed record")
+	require.Contains(t, all, "segment 100: on disk but not in the manifest")
+	require.NotContains(t, all, "standby")
+}
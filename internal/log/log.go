@@ -1,28 +1,48 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	api "github.com/hamza-yusuff/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 // the log consists of a list of segments and a pointer to the active segment where data is written to
 // dir refers to the directory where the segment ( files index and store ) is stored
 
 type Log struct {
-	mu            sync.RWMutex
+	// mu can be waited on with a context, see context.go
+	mu            *rwMutex
 	Config        Config
 	Dir           string
 	activeSegment *segment
-	segments      []*segment
+	// segments is sorted by base offset, the segments other than the active one are opened on
+	// demand through the pool
+	segments []*segment
+	pool     *segmentPool
+	cache    *recordCache
+	standby  *standbyPreparer
+	metrics  *logMetrics
+	hooks    hooks
+	// closed is set by Close, appends, reads and truncates fail with ErrLogClosed afterwards
+	closed bool
+	// appended is closed when records are appended, see wait.go
+	appended chan struct{}
+	// writeErr is the error of the append that failed, see health.go
+	writeErr error
+	// syncs are the syncs of SyncWrites in progress, see context.go
+	syncs sync.WaitGroup
 }
 
 // creatng and setting up the log instance
@@ -35,13 +55,51 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1024
 	}
+	if c.Segment.FormatVersion > formatVersion {
+		return nil, fmt.Errorf(
+			"log: unsupported format version %d, want at most %d",
+			c.Segment.FormatVersion, formatVersion,
+		)
+	}
+	if err := validateOffsetWidth(c); err != nil {
+		return nil, err
+	}
 	log := &Log{
-		Dir:    dir,
-		Config: c,
+		mu:       newRWMutex(),
+		Dir:      dir,
+		Config:   c,
+		cache:    newRecordCache(c.Cache.MaxBytes),
+		metrics:  newLogMetrics(),
+		appended: make(chan struct{}),
 	}
 	return log, log.setup()
 }
 
+// validateOffsetWidth checks that every entry a segment's index has room for gets a relative
+// offset the index can store. With a narrow index, a MaxIndexBytes of more than 48GiB would let the
+// relative offsets of a segment go past math.MaxUint32.
+func validateOffsetWidth(c Config) error {
+	if c.Segment.WideIndex {
+		if c.formatVersion() < formatV2 {
+			return fmt.Errorf("log: a wide index requires format version %d", formatV2)
+		}
+		if c.Segment.MaxIndexBytes < wideEntWidth {
+			return fmt.Errorf("log: MaxIndexBytes of %d has no room for an index entry", c.Segment.MaxIndexBytes)
+		}
+		return nil
+	}
+	if c.Segment.MaxIndexBytes < entWidth {
+		return fmt.Errorf("log: MaxIndexBytes of %d has no room for an index entry", c.Segment.MaxIndexBytes)
+	}
+	if entries := c.Segment.MaxIndexBytes / entWidth; entries > math.MaxUint32+1 {
+		return fmt.Errorf(
+			"log: MaxIndexBytes allows %d entries per segment, more than a narrow index can address, set WideIndex",
+			entries,
+		)
+	}
+	return nil
+}
+
 // setting up the log instance
 // when the log starts it sets itselfup for the segemnts on the disk that already exist,
 // if there is none, it configures a segment straightup. If present already, the baseoffset
@@ -49,6 +107,23 @@ func NewLog(dir string, c Config) (*Log, error) {
 // are created from the baseOffset numbers obatined and sorted. Each offset number correspond
 // to a segment on the disk
 func (l *Log) setup() error {
+	// the directory is created if needed, Reset removes it before setting the log up again
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	l.segments, l.activeSegment = nil, nil
+	l.closed, l.writeErr = false, nil
+	l.pool = newSegmentPool(l.Config.Segment.MaxOpenSegments)
+
+	m, hasManifest, err := readManifest(l.Dir)
+	if err != nil {
+		return err
+	}
+	if hasManifest {
+		if err = m.validate(l.Config); err != nil {
+			return err
+		}
+	}
 
 	files, err := ioutil.ReadDir(l.Dir)
 	if err != nil {
@@ -64,7 +139,11 @@ func (l *Log) setup() error {
 			path.Ext(file.Name()),
 		)
 
-		off, _ := strconv.ParseUint(offStr, 10, 0)
+		// files that aren't named after a base offset, like the manifest, are not segment files
+		off, err := strconv.ParseUint(offStr, 10, 0)
+		if err != nil {
+			continue
+		}
 		baseOffsets = append(baseOffsets, off)
 	}
 	// sort the baseOffset numbers
@@ -72,14 +151,27 @@ func (l *Log) setup() error {
 		return baseOffsets[i] < baseOffsets[j]
 	})
 
-	for i := 0; i < len(baseOffsets); i++ {
+	if hasManifest {
+		if baseOffsets, err = l.reconcile(m, baseOffsets); err != nil {
+			return err
+		}
+	}
+
+	// baseOffsets contain duplicates which we safely ignore, this is we parse both index and store files above in the first
+	// loop
+	// only the last segment, which becomes the active one, is opened here, the others are left
+	// closed until they are read from
+	for i := 0; i < len(baseOffsets); i += 2 {
+		if i+2 < len(baseOffsets) {
+			l.segments = append(l.segments, closedSegment(
+				l.Dir, baseOffsets[i], baseOffsets[i+2], l.Config,
+			))
+			continue
+		}
 		// inheritance through embedding
 		if err = l.newSegment(baseOffsets[i]); err != nil {
 			return err
 		}
-		// baseOffsets contain duplicates which we safely ignore, this is we parse both index and store files above in the first
-		// loop
-		i++
 	}
 
 	if l.segments == nil {
@@ -89,50 +181,208 @@ func (l *Log) setup() error {
 			return err
 		}
 	}
-	return nil
+	if err = l.writeManifest(); err != nil {
+		return err
+	}
+	l.standby, err = startStandby(l.Dir, l.Config)
+	return err
+}
+
+// reconcile compares the segment files found on disk with the segments listed in the manifest.
+// Every segment in the manifest must still be on disk. Files older than the first segment in the
+// manifest are left over from a Truncate that was interrupted after the manifest was updated, so
+// they are removed. Files newer than the last segment in the manifest come from a roll that was
+// interrupted before the manifest was updated, so they are kept.
+// baseOffsets holds every base offset twice (once for the store, once for the index file), the
+// returned slice keeps that layout.
+func (l *Log) reconcile(m *manifest, baseOffsets []uint64) ([]uint64, error) {
+	onDisk := make(map[uint64]bool, len(baseOffsets))
+	for _, off := range baseOffsets {
+		onDisk[off] = true
+	}
+	for _, off := range m.Segments {
+		if !onDisk[off] {
+			return nil, fmt.Errorf("log: segment %d is in the manifest but not on disk", off)
+		}
+	}
+	if len(m.Segments) == 0 {
+		return baseOffsets, nil
+	}
+
+	var kept []uint64
+	for i := 0; i < len(baseOffsets); i += 2 {
+		off := baseOffsets[i]
+		if off >= m.Segments[0] {
+			kept = append(kept, off, off)
+			continue
+		}
+		for _, ext := range []string{".store", ".index"} {
+			name := path.Join(l.Dir, fmt.Sprintf("%d%s", off, ext))
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	return kept, nil
+}
+
+// writeManifest persists the manifest for the current list of segments
+func (l *Log) writeManifest() error {
+	m := &manifest{
+		FormatVersion: int(l.Config.formatVersion()),
+		Config:        newManifestConfig(l.Config),
+		Segments:      make([]uint64, len(l.segments)),
+	}
+	for i, s := range l.segments {
+		m.Segments[i] = s.baseOffset
+	}
+	return writeManifest(l.Dir, m)
 }
 
 // append a log to the active segment, if the segment is maxed out another segement is created
 // RWMutex is chosen to grant access to reads when there is not a write holding the lock
 func (l *Log) Append(record *api.Record) (uint64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	off, err := l.activeSegment.Append(record)
-	if err != nil {
+	return l.AppendContext(context.Background(), record)
+}
+
+// AppendContext is Append that gives up when ctx is done while it waits for the lock, or for the
+// record to be synced to disk when SyncWrites is set. A record whose sync was given up on is in the
+// log, its offset is returned together with the context's error.
+func (l *Log) AppendContext(ctx context.Context, record *api.Record) (off uint64, err error) {
+	// deferred first so the hooks run after the lock is released
+	var events []SegmentEvent
+	defer func() { l.hooks.fire(events) }()
+
+	start := time.Now()
+	defer func() { l.metrics.observe(l.metrics.appendLatency, l.metrics.appendErrors, start, err) }()
+
+	if err = lockContext(ctx, l.mu); err != nil {
 		return 0, err
 	}
+	active := l.activeSegment
+	off, events, err = l.append(record)
+	// the sync is started under the lock, so the segment can't be truncated away before it
+	var synced <-chan error
+	if err == nil && l.Config.Segment.SyncWrites {
+		synced = l.syncSegment(active)
+	}
+	l.mu.Unlock()
+	if synced == nil {
+		return off, err
+	}
+	select {
+	case err = <-synced:
+		return off, err
+	case <-ctx.Done():
+		return off, ctx.Err()
+	}
+}
+
+// append does the work of Append, l.mu must be held
+func (l *Log) append(record *api.Record) (off uint64, events []SegmentEvent, err error) {
+	if l.closed {
+		return 0, nil, ErrLogClosed
+	}
+	active := l.activeSegment
+	// the record is measured as it will be stored, with its offset and framing
+	if max := l.Config.Segment.MaxRecordBytes; max > 0 {
+		record.Offset = active.nextOffset
+		if size := active.store.entrySize(uint64(proto.Size(record))); size > max {
+			return 0, nil, &RecordTooLargeError{Size: size, Max: max}
+		}
+	}
+	before := active.store.size
+	off, err = active.Append(record)
+	if err != nil {
+		l.writeErr = err
+		return 0, nil, err
+	}
+	l.metrics.appendedRecords.Inc()
+	l.metrics.appendedBytes.Add(float64(active.store.size - before))
+	l.notify()
 
 	// check if the segment is maxed out
-	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(off + 1)
+	if active.IsMaxed() {
+		events, err = l.rollActive()
+	}
+	return off, events, err
+}
+
+// rollActive seals the active segment and makes a new one active, l.mu must be held
+func (l *Log) rollActive() (events []SegmentEvent, err error) {
+	rollStart := time.Now()
+	active := l.activeSegment
+	// the sealed store is written out so its size and modification time on disk are those of its
+	// last append, see retention.go
+	if err = active.store.flush(); err == nil {
+		err = l.roll(active.nextOffset)
+	}
+	if err == nil {
+		events = append(events,
+			newSegmentEvent(SegmentSealed, active),
+			newSegmentEvent(SegmentCreated, l.activeSegment),
+		)
+		err = l.writeManifest()
+	}
+	if err != nil {
+		l.writeErr = err
 	}
-	return off, err
+	l.metrics.rolls.Inc()
+	l.metrics.rollLatency.Observe(since(rollStart))
+	return events, err
 }
 
 func (l *Log) Read(off uint64) (*api.Record, error) {
+	return l.ReadContext(context.Background(), off)
+}
+
+// ReadContext is Read that gives up when ctx is done while it waits for the lock
+func (l *Log) ReadContext(ctx context.Context, off uint64) (record *api.Record, err error) {
+	start := time.Now()
+	defer func() { l.metrics.observe(l.metrics.readLatency, l.metrics.readErrors, start, err) }()
 	// read locks
-	l.mu.RLock()
+	if err = rlockContext(ctx, l.mu); err != nil {
+		return nil, err
+	}
 	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, ErrLogClosed
+	}
 
-	var seg *segment
-
-	// look for the segment where the record can be found
-	// looked with the off set value
-	// iterated until to find the first segment whose base offset is less than or equal to
-	// the offset of the record being sought after
-
-	for _, segment := range l.segments {
-		if segment.baseOffset <= off && off < segment.nextOffset {
-			seg = segment
-			break
-		}
+	// only offsets inside the log are ever cached, the cache is purged of the others when the
+	// log is truncated or reset
+	if record, ok := l.cache.get(off); ok {
+		return record, nil
 	}
 
+	seg := l.findSegment(off)
 	if seg == nil || seg.nextOffset <= off {
-		return nil, fmt.Errorf("offset out of reange: %d", off)
+		return nil, &OffsetOutOfRangeError{Offset: off}
 	}
-	return seg.Read(off)
+	if err := l.pool.acquire(seg); err != nil {
+		return nil, err
+	}
+	defer l.pool.release(seg)
+	record, err = seg.Read(off)
+	if err != nil {
+		return nil, err
+	}
+	l.cache.put(off, record)
+	return record, nil
+
+}
 
+// findSegment looks for the segment where the record can be found
+// the segments are sorted by their base offsets, so a binary search finds the last segment whose
+// base offset is less than or equal to the offset of the record being sought after
+func (l *Log) findSegment(off uint64) *segment {
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].baseOffset > off
+	})
+	if i == 0 {
+		return nil
+	}
+	return l.segments[i-1]
 }
 
 // close method iterates over the segmetn and closes them, which in turn closes the index ans store files
@@ -140,13 +390,15 @@ func (l *Log) Read(off uint64) (*api.Record, error) {
 func (l *Log) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.closed = true
+	l.notify()
+	l.syncs.Wait()
 
-	for _, segment := range l.segments {
-		if err := segment.Close(); err != nil {
-			return err
-		}
+	if l.standby != nil {
+		l.standby.close()
+		l.standby = nil
 	}
-	return nil
+	return l.pool.closeAll()
 }
 
 // remove closes the log, and removes the data
@@ -164,8 +416,30 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	l.cache.purge()
 
-	return l.setup()
+	l.mu.Lock()
+	err := l.setup()
+	var events []SegmentEvent
+	if err == nil {
+		events = []SegmentEvent{
+			{Type: LogReset},
+			newSegmentEvent(SegmentCreated, l.activeSegment),
+		}
+	}
+	l.mu.Unlock()
+	l.hooks.fire(events)
+	return err
+}
+
+// returns the hit and miss counters and the size of the record cache
+func (l *Log) CacheStats() CacheStats {
+	return l.cache.stats()
+}
+
+// MaxRecordBytes returns the most bytes a record may take, zero when records aren't limited
+func (l *Log) MaxRecordBytes() uint64 {
+	return l.Config.Segment.MaxRecordBytes
 }
 
 // Added to support replicated, coordinated cluster
@@ -190,29 +464,110 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return off - 1, nil
 }
 
-// removes all segments whose highest offset is higher than the lowest offset
+// NextOffset returns the offset the next record appended gets. Unlike HighestOffset it tells an
+// empty log apart, its next offset is the base offset of its only segment.
+func (l *Log) NextOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.segments[len(l.segments)-1].nextOffset, nil
+}
+
+// removes all segments whose highest offset is at or below the lowest offset
 // this will be called to remove old segments whose does have been processed
 
-func (l *Log) Truncate(lowest uint64) error {
+func (l *Log) Truncate(lowest uint64) (err error) {
+	// deferred first so the hooks run after the lock is released
+	var events []SegmentEvent
+	defer func() { l.hooks.fire(events) }()
+
+	start := time.Now()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	defer func() {
+		l.metrics.truncates.Inc()
+		l.metrics.truncateLatency.Observe(since(start))
+	}()
 
-	var segments []*segment
+	var segments, removed []*segment
 
+	// the active segment is kept even when all of its records are below lowest, the log needs it
+	// to append to
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
-			if err := s.Remove(); err != nil {
-				return err
-			}
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
+			removed = append(removed, s)
 			continue
 		}
 		segments = append(segments, s)
 	}
 
+	// the manifest is updated before the files are removed, if the removal is interrupted the
+	// leftover files get cleaned up the next time the log is set up
 	l.segments = segments
+	if len(removed) > 0 {
+		l.syncs.Wait()
+	}
+	if len(segments) > 0 {
+		l.cache.removeBelow(segments[0].baseOffset)
+	} else {
+		l.cache.purge()
+	}
+	if err := l.writeManifest(); err != nil {
+		return err
+	}
+	for _, s := range removed {
+		l.pool.remove(s)
+		if err := s.Remove(); err != nil {
+			return err
+		}
+		events = append(events, newSegmentEvent(SegmentRemoved, s))
+	}
 	return nil
 }
 
+// poolReader reads a segment's store through the pool, the segment is only held open between its
+// first read and the end of its store
+type poolReader struct {
+	log *Log
+	seg *segment
+	r   io.Reader
+}
+
+func (p *poolReader) Read(b []byte) (int, error) {
+	if p.r == nil {
+		if err := p.acquire(); err != nil {
+			return 0, err
+		}
+		p.r = p.seg.store.reader()
+	}
+	n, err := p.r.Read(b)
+	if err == io.EOF {
+		p.log.pool.release(p.seg)
+		p.r = eofReader{}
+	}
+	return n, err
+}
+
+// acquire holds the read lock so the segment can't be truncated away, nor the log closed or
+// removed, while the pool opens its files, which would create them again
+func (p *poolReader) acquire() error {
+	p.log.mu.RLock()
+	defer p.log.mu.RUnlock()
+	if p.log.closed {
+		return ErrLogClosed
+	}
+	return p.log.pool.acquire(p.seg)
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
 type originReader struct {
 	*store
 	off int64
@@ -234,11 +589,23 @@ func (l *Log) Reader() io.Reader {
 
 	readers := make([]io.Reader, len(l.segments))
 	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+		readers[i] = &poolReader{log: l, seg: segment}
 	}
 	return io.MultiReader(readers...)
 }
 
+// roll replaces the active segment with a new one starting at off, the segment prepared in the
+// background is used when it's ready
+func (l *Log) roll(off uint64) error {
+	if l.standby != nil {
+		if seg := l.standby.take(off); seg != nil {
+			l.addSegment(seg)
+			return nil
+		}
+	}
+	return l.newSegment(off)
+}
+
 // makes a new segments, and assigns that as the active segment for the log
 func (l *Log) newSegment(off uint64) error {
 	seg, err := newSegment(l.Dir, off, l.Config)
@@ -247,7 +614,12 @@ func (l *Log) newSegment(off uint64) error {
 		return err
 	}
 
+	l.addSegment(seg)
+	return nil
+}
+
+func (l *Log) addSegment(seg *segment) {
 	l.segments = append(l.segments, seg)
 	l.activeSegment = seg
-	return nil
+	l.pool.setActive(seg)
 }
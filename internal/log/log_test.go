@@ -90,6 +90,9 @@ func testInitExisting(t *testing.T, o *Log) {
 	off, err = n.HighestOffset()
 	require.NoError(t, err)
 	require.Equal(t, uint64(2), off)
+	off, err = n.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
 
 }
 
@@ -132,3 +135,79 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+func TestNewLogOffsetWidth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offset-width-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a narrow index can't address the entries of a 64GiB index
+	c := Config{}
+	c.Segment.MaxIndexBytes = 64 << 30
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+
+	// a wide index needs the segment header of version 2
+	c.Segment.WideIndex = true
+	c.Segment.FormatVersion = formatV1
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+
+	// an index needs room for an entry
+	c = Config{}
+	c.Segment.MaxIndexBytes = entWidth - 1
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+}
+
+func TestIndexRolls(t *testing.T) {
+	// the default of the log isn't a whole number of entries, the server's is
+	for _, maxIndexBytes := range []uint64{0, 1200} {
+		for _, wide := range []bool{false, true} {
+			dir, err := ioutil.TempDir("", "index-rolls-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			// small records fill the index before the store
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1024
+			c.Segment.MaxIndexBytes = maxIndexBytes
+			c.Segment.WideIndex = wide
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := uint64(0); i < 300; i++ {
+				off, err := log.Append(&api.Record{})
+				require.NoError(t, err)
+				require.Equal(t, i, off)
+			}
+			require.Greater(t, len(log.segments), 2)
+			for off := uint64(0); off < 300; off++ {
+				_, err := log.Read(off)
+				require.NoError(t, err)
+			}
+			require.NoError(t, log.Close())
+		}
+	}
+}
+
+func TestTruncateKeepsActiveSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "truncate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	append := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(append)
+	require.NoError(t, err)
+	require.NoError(t, log.Truncate(10))
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
@@ -0,0 +1,71 @@
+package log
+
+// Maintenance of an open log, for the admin endpoints of the server. Segments and Verify list the
+// files like Inspect does, see inspect.go, while holding the read lock so no append is halfway
+// done, after writing out the buffered appends of the active segment. Verify reads the records
+// after releasing the lock so appends aren't held up, it only checks the entries and store bytes
+// there were when the segments were listed, and skips the segments removed since.
+
+// Segments describes the segments of the log, oldest first
+func (l *Log) Segments() ([]SegmentInfo, error) {
+	info, err := l.inspect()
+	if err != nil {
+		return nil, err
+	}
+	return info.Segments, nil
+}
+
+// Verify checks the segments of the log for inconsistencies like LogInfo.Check does, without the
+// unused index entries every open segment has, and without what is appended while it runs
+func (l *Log) Verify() ([]Problem, error) {
+	info, err := l.inspect()
+	if err != nil {
+		return nil, err
+	}
+	for i := range info.Segments {
+		info.Segments[i].open = true
+	}
+	return info.Check()
+}
+
+func (l *Log) inspect() (*LogInfo, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, ErrLogClosed
+	}
+	active := l.activeSegment
+	if err := active.store.flush(); err != nil {
+		return nil, err
+	}
+	info, err := Inspect(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range info.Segments {
+		info.Segments[i].Active = info.Segments[i].BaseOffset == active.baseOffset
+	}
+	return info, nil
+}
+
+// Roll seals the active segment and starts a new one even though the active segment isn't full,
+// so it can be truncated away or copied elsewhere. An empty active segment is kept, a new segment
+// would have the same base offset. It returns the base offset of the active segment afterwards.
+func (l *Log) Roll() (uint64, error) {
+	// deferred first so the hooks run after the lock is released
+	var events []SegmentEvent
+	defer func() { l.hooks.fire(events) }()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+	if active := l.activeSegment; active.nextOffset > active.baseOffset {
+		var err error
+		if events, err = l.rollActive(); err != nil {
+			return 0, err
+		}
+	}
+	return l.activeSegment.baseOffset, nil
+}
@@ -0,0 +1,105 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-maintenance-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	base, err := log.Roll()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), base)
+	// the new active segment is empty, rolling it again does nothing
+	base, err = log.Roll()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), base)
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.Equal(t, uint64(0), segments[0].BaseOffset)
+	require.Equal(t, uint64(3), segments[0].NextOffset)
+	require.False(t, segments[0].Active)
+	require.Equal(t, uint64(3), segments[1].BaseOffset)
+	require.Equal(t, uint64(4), segments[1].NextOffset)
+	require.True(t, segments[1].Active)
+	require.NotZero(t, segments[1].StoreBytes)
+
+	problems, err := log.Verify()
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// bytes no index entry points at are found
+	f, err := os.OpenFile(segments[0].StorePath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("garbage"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	problems, err = log.Verify()
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Equal(t, uint64(0), problems[0].BaseOffset)
+
+	require.NoError(t, log.Close())
+	_, err = log.Roll()
+	require.ErrorIs(t, err, ErrLogClosed)
+	_, err = log.Verify()
+	require.ErrorIs(t, err, ErrLogClosed)
+}
+
+func TestVerifyWhileAppending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-maintenance-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// what is appended, and truncated away, while Verify runs isn't a problem
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2000; i++ {
+			off, err := log.Append(&api.Record{Value: []byte("hello world")})
+			if err == nil && i%500 == 499 {
+				err = log.Truncate(off - 100)
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			return
+		default:
+		}
+		problems, err := log.Verify()
+		require.NoError(t, err)
+		require.Empty(t, problems)
+	}
+}
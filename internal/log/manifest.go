@@ -0,0 +1,124 @@
+package log
+
+// The manifest is a small JSON file kept next to the segment files of a log. It records the
+// on-disk format version, the configuration the log was created with, and the base offsets of the
+// segments that currently make up the log. setup validates the manifest when a log is reopened so
+// that opening an existing directory with different segment settings fails loudly, rather than
+// silently changing how the segments are sized and read.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	// manifestName is the file name of the manifest inside the log directory
+	manifestName = "MANIFEST"
+	// formatVersion is the latest on-disk format version, see store.go for the formats
+	formatVersion = 2
+)
+
+// manifest is the JSON document persisted at <dir>/MANIFEST
+// FormatVersion is the version new segments of the log are written in, older segments keep the
+// version from their segment header until they are migrated.
+type manifest struct {
+	FormatVersion int            `json:"format_version"`
+	Config        manifestConfig `json:"config"`
+	Segments      []uint64       `json:"segments"`
+}
+
+// manifestConfig is the part of Config that changes how existing segments are interpreted
+type manifestConfig struct {
+	MaxStoreBytes uint64 `json:"max_store_bytes"`
+	MaxIndexBytes uint64 `json:"max_index_bytes"`
+	InitialOffset uint64 `json:"initial_offset"`
+}
+
+func newManifestConfig(c Config) manifestConfig {
+	return manifestConfig{
+		MaxStoreBytes: c.Segment.MaxStoreBytes,
+		MaxIndexBytes: c.Segment.MaxIndexBytes,
+		InitialOffset: c.Segment.InitialOffset,
+	}
+}
+
+// readManifest reads the manifest from the log directory. The returned bool is false when the
+// directory does not have a manifest yet, which is the case for new logs and for logs written
+// before the manifest was introduced.
+func readManifest(dir string) (*manifest, bool, error) {
+	b, err := ioutil.ReadFile(path.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	m := &manifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, false, fmt.Errorf("log: invalid manifest: %w", err)
+	}
+	return m, true, nil
+}
+
+// validate checks that a log written with the manifest can be opened with the config c
+func (m *manifest) validate(c Config) error {
+	if m.FormatVersion < 1 || m.FormatVersion > formatVersion {
+		return fmt.Errorf(
+			"log: unsupported format version %d, want at most %d",
+			m.FormatVersion, formatVersion,
+		)
+	}
+	if want := newManifestConfig(c); m.Config != want {
+		return fmt.Errorf(
+			"log: config mismatch: log was created with %+v, opened with %+v",
+			m.Config, want,
+		)
+	}
+	return nil
+}
+
+// writeManifest atomically replaces the manifest in dir. The new content is written and synced to
+// a temporary file first, which is then renamed over the old manifest, so that a crash in the
+// middle of the write leaves either the old or the new manifest behind but never a partial one.
+func writeManifest(dir string, m *manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, manifestName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path.Join(dir, manifestName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entry so that a rename inside it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,102 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.InitialOffset = 5
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	// a new log records its format version, config and first segment
+	m, ok, err := readManifest(dir)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, formatVersion, m.FormatVersion)
+	require.Equal(t, uint64(32), m.Config.MaxStoreBytes)
+	require.Equal(t, uint64(1024), m.Config.MaxIndexBytes)
+	require.Equal(t, uint64(5), m.Config.InitialOffset)
+	require.Equal(t, []uint64{5}, m.Segments)
+
+	// rolling the active segment adds it to the manifest
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	m, _, err = readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5, 7}, m.Segments)
+
+	// truncating removes the segment from the manifest
+	require.NoError(t, log.Truncate(6))
+	m, _, err = readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{7}, m.Segments)
+	require.NoError(t, log.Close())
+
+	// reopening with a different config fails instead of silently changing behavior
+	other := c
+	other.Segment.MaxStoreBytes = 64
+	_, err = NewLog(dir, other)
+	require.Error(t, err)
+
+	// reopening with the same config works
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), off)
+	require.NoError(t, log.Close())
+}
+
+func TestManifestReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-reconcile-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// simulate a Truncate that was interrupted after the manifest was written
+	m, _, err := readManifest(dir)
+	require.NoError(t, err)
+	m.Segments = m.Segments[1:]
+	require.NoError(t, writeManifest(dir, m))
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	_, err = os.Stat(path.Join(dir, "0.store"))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, log.Close())
+
+	// a segment listed in the manifest but missing on disk is an error
+	require.NoError(t, os.Remove(path.Join(dir, "2.store")))
+	require.NoError(t, os.Remove(path.Join(dir, "2.index")))
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package log
+
+// Metrics of the log, in the form of a prometheus.Collector. The Log itself implements the
+// collector interface, so a program embedding the log exposes its metrics by registering it:
+//
+//	reg.MustRegister(log)
+//
+// Latencies and counters are recorded as the log is used, the state of the segments and of the
+// record cache is read when the metrics are collected.
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "proglog"
+	metricsSubsystem = "log"
+)
+
+type logMetrics struct {
+	appendLatency   prometheus.Histogram
+	readLatency     prometheus.Histogram
+	rollLatency     prometheus.Histogram
+	truncateLatency prometheus.Histogram
+	appendedBytes   prometheus.Counter
+	appendedRecords prometheus.Counter
+	appendErrors    prometheus.Counter
+	readErrors      prometheus.Counter
+	rolls           prometheus.Counter
+	truncates       prometheus.Counter
+
+	segments          *prometheus.Desc
+	openSegments      *prometheus.Desc
+	activeStoreBytes  *prometheus.Desc
+	activeIndexBytes  *prometheus.Desc
+	activeSegmentFill *prometheus.Desc
+	lowestOffset      *prometheus.Desc
+	highestOffset     *prometheus.Desc
+	cacheHits         *prometheus.Desc
+	cacheMisses       *prometheus.Desc
+	cacheBytes        *prometheus.Desc
+}
+
+func newLogMetrics() *logMetrics {
+	histogram := func(name, help string) prometheus.Histogram {
+		return prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      name,
+			Help:      help,
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		})
+	}
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      name,
+			Help:      help,
+		})
+	}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, metricsSubsystem, name),
+			help, nil, nil,
+		)
+	}
+	return &logMetrics{
+		appendLatency:   histogram("append_duration_seconds", "Time taken to append a record."),
+		readLatency:     histogram("read_duration_seconds", "Time taken to read a record."),
+		rollLatency:     histogram("roll_duration_seconds", "Time taken to roll the active segment."),
+		truncateLatency: histogram("truncate_duration_seconds", "Time taken to truncate the log."),
+		appendedBytes:   counter("appended_bytes_total", "Bytes written to the store files."),
+		appendedRecords: counter("appended_records_total", "Records appended to the log."),
+		appendErrors:    counter("append_errors_total", "Appends that failed."),
+		readErrors:      counter("read_errors_total", "Reads that failed, including reads out of range."),
+		rolls:           counter("rolls_total", "Times the active segment was rolled."),
+		truncates:       counter("truncates_total", "Times the log was truncated."),
+
+		segments:          desc("segments", "Segments in the log."),
+		openSegments:      desc("open_segments", "Segments with their files open."),
+		activeStoreBytes:  desc("active_segment_store_bytes", "Size of the store file of the active segment."),
+		activeIndexBytes:  desc("active_segment_index_bytes", "Size of the index of the active segment."),
+		activeSegmentFill: desc("active_segment_fill_ratio", "How full the active segment is, from 0 to 1."),
+		lowestOffset:      desc("lowest_offset", "Lowest offset in the log."),
+		highestOffset:     desc("highest_offset", "Highest offset in the log."),
+		cacheHits:         desc("cache_hits_total", "Reads served from the record cache."),
+		cacheMisses:       desc("cache_misses_total", "Reads that missed the record cache."),
+		cacheBytes:        desc("cache_bytes", "Bytes of records in the record cache."),
+	}
+}
+
+func (m *logMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.appendLatency, m.readLatency, m.rollLatency, m.truncateLatency,
+		m.appendedBytes, m.appendedRecords, m.appendErrors, m.readErrors,
+		m.rolls, m.truncates,
+	}
+}
+
+func (m *logMetrics) descs() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		m.segments, m.openSegments, m.activeStoreBytes, m.activeIndexBytes,
+		m.activeSegmentFill, m.lowestOffset, m.highestOffset,
+		m.cacheHits, m.cacheMisses, m.cacheBytes,
+	}
+}
+
+// observe records the latency of an operation and counts it as failed if err isn't nil
+func (m *logMetrics) observe(latency prometheus.Histogram, errors prometheus.Counter, start time.Time, err error) {
+	latency.Observe(since(start))
+	if err != nil {
+		errors.Inc()
+	}
+}
+
+// since returns the seconds elapsed since start, for the latency histograms
+func since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Describe implements prometheus.Collector
+func (l *Log) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range l.metrics.collectors() {
+		c.Describe(ch)
+	}
+	for _, d := range l.metrics.descs() {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (l *Log) Collect(ch chan<- prometheus.Metric) {
+	m := l.metrics
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	l.mu.RLock()
+	gauge(m.segments, float64(len(l.segments)))
+	gauge(m.openSegments, float64(l.pool.open()))
+	if len(l.segments) > 0 {
+		gauge(m.lowestOffset, float64(l.segments[0].baseOffset))
+		if next := l.segments[len(l.segments)-1].nextOffset; next > 0 {
+			gauge(m.highestOffset, float64(next-1))
+		}
+	}
+	if s := l.activeSegment; s != nil && s.isOpen() {
+		gauge(m.activeStoreBytes, float64(s.store.size))
+		gauge(m.activeIndexBytes, float64(s.index.size))
+		gauge(m.activeSegmentFill, s.fill())
+	}
+	l.mu.RUnlock()
+
+	stats := l.cache.stats()
+	ch <- prometheus.MustNewConstMetric(m.cacheHits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(m.cacheMisses, prometheus.CounterValue, float64(stats.Misses))
+	gauge(m.cacheBytes, float64(stats.Bytes))
+}
@@ -0,0 +1,157 @@
+package log
+
+// Offline migration of a log directory to the latest on-disk format.
+// Every segment written in an older format is copied record by record into a new segment inside
+// a temporary directory ( <dir>/.migrate ). Only once all of them are written, a commit marker is
+// created and the new files are renamed over the old ones. If the migration is interrupted before
+// the marker exists, the temporary directory is thrown away the next time Migrate runs. If it is
+// interrupted after, the next run finishes the renames, so a segment never ends up with a store in
+// one format and an index pointing at positions of the other.
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	migrateDir    = ".migrate"
+	migrateCommit = "COMMIT"
+)
+
+// Migrate rewrites every segment of the log in dir that is older than the latest format version
+// and returns the number of segments that were migrated. The log must not be open while it's being
+// migrated. c must match the config the log was created with, see ReadConfig.
+func Migrate(dir string, c Config) (int, error) {
+	if err := finishMigration(dir); err != nil {
+		return 0, err
+	}
+
+	// new segments, and the manifest, are written in the latest version from here on
+	c.Segment.FormatVersion = 0
+	l, err := NewLog(dir, c)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := path.Join(dir, migrateDir)
+	if err = os.MkdirAll(tmp, 0755); err != nil {
+		l.Close()
+		return 0, err
+	}
+
+	var migrated int
+	for _, s := range l.segments {
+		if err = l.pool.acquire(s); err != nil {
+			l.Close()
+			return 0, err
+		}
+		if s.store.version < formatVersion {
+			err = migrateSegment(tmp, s, c)
+			migrated++
+		}
+		l.pool.release(s)
+		if err != nil {
+			l.Close()
+			return 0, err
+		}
+	}
+	if err = l.Close(); err != nil {
+		return 0, err
+	}
+
+	commit, err := os.Create(path.Join(tmp, migrateCommit))
+	if err != nil {
+		return 0, err
+	}
+	if err = commit.Sync(); err != nil {
+		commit.Close()
+		return 0, err
+	}
+	if err = commit.Close(); err != nil {
+		return 0, err
+	}
+	if err = syncDir(tmp); err != nil {
+		return 0, err
+	}
+	return migrated, finishMigration(dir)
+}
+
+// migrateSegment copies the records of s into a new segment with the same base offset in dir
+func migrateSegment(dir string, s *segment, c Config) error {
+	n, err := newSegment(dir, s.baseOffset, c)
+	if err != nil {
+		return err
+	}
+	for off := s.baseOffset; off < s.nextOffset; off++ {
+		_, pos, err := s.index.Read(int64(off - s.baseOffset))
+		if err != nil {
+			n.Close()
+			return err
+		}
+		p, err := s.store.Read(pos)
+		if err != nil {
+			n.Close()
+			return err
+		}
+		if _, pos, err = n.store.Append(p); err != nil {
+			n.Close()
+			return err
+		}
+		if err = n.index.Write(off-s.baseOffset, pos); err != nil {
+			n.Close()
+			return err
+		}
+		n.nextOffset++
+	}
+	return n.Close()
+}
+
+// finishMigration moves the segments of a committed migration into place, and throws away the
+// temporary directory of a migration that never got to commit
+func finishMigration(dir string) error {
+	tmp := path.Join(dir, migrateDir)
+	files, err := ioutil.ReadDir(tmp)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(path.Join(tmp, migrateCommit)); os.IsNotExist(err) {
+		return os.RemoveAll(tmp)
+	} else if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Name() == migrateCommit {
+			continue
+		}
+		if err = os.Rename(
+			path.Join(tmp, file.Name()),
+			path.Join(dir, file.Name()),
+		); err != nil {
+			return err
+		}
+	}
+	if err = syncDir(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(tmp)
+}
+
+// ReadConfig returns the config recorded in the manifest of the log in dir. The returned bool is
+// false if the directory has no manifest.
+func ReadConfig(dir string) (Config, bool, error) {
+	var c Config
+	m, ok, err := readManifest(dir)
+	if err != nil || !ok {
+		return c, ok, err
+	}
+	c.Segment.MaxStoreBytes = m.Config.MaxStoreBytes
+	c.Segment.MaxIndexBytes = m.Config.MaxIndexBytes
+	c.Segment.InitialOffset = m.Config.InitialOffset
+	return c, true, nil
+}
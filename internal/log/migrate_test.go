@@ -0,0 +1,64 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.FormatVersion = formatV1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// an interrupted migration that never committed is thrown away
+	require.NoError(t, os.MkdirAll(path.Join(dir, migrateDir), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, migrateDir, "0.store"), []byte("junk"), 0644))
+
+	rc, ok, err := ReadConfig(dir)
+	require.NoError(t, err)
+	require.True(t, ok)
+	n, err := Migrate(dir, rc)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	_, err = os.Stat(path.Join(dir, migrateDir))
+	require.True(t, os.IsNotExist(err))
+
+	m, _, err := readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, formatVersion, m.FormatVersion)
+
+	log, err = NewLog(dir, rc)
+	require.NoError(t, err)
+	for off := uint64(0); off < 5; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+	// reading opened every segment
+	for _, s := range log.segments {
+		require.Equal(t, formatV2, s.store.version)
+	}
+	require.NoError(t, log.Close())
+
+	// nothing is left to migrate
+	n, err = Migrate(dir, rc)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
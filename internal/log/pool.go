@@ -0,0 +1,131 @@
+package log
+
+// The segment pool keeps track of which segments have their files open. Only the active segment is
+// opened when the log is set up, every other segment is opened the first time it is read from.
+// Each open segment holds two file descriptors and an mmap of MaxIndexBytes, so with a limit set,
+// the least recently used segments are closed again once more than the limit are open.
+// Segments are reference counted while a read is using them, so a segment is never closed from
+// under a reader, the pool can go over its limit for as long as all the open segments are in use.
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+type segmentPool struct {
+	mu sync.Mutex
+	// max is the most segments kept open, zero means no limit
+	max int
+	// lru holds the open segments, the most recently used at the front
+	lru    *list.List
+	elems  map[*segment]*list.Element
+	refs   map[*segment]int
+	active *segment
+}
+
+func newSegmentPool(max int) *segmentPool {
+	return &segmentPool{
+		max:   max,
+		lru:   list.New(),
+		elems: make(map[*segment]*list.Element),
+		refs:  make(map[*segment]int),
+	}
+}
+
+// setActive adds the open active segment to the pool, the active segment is never evicted
+func (p *segmentPool) setActive(s *segment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = s
+	p.touch(s)
+	p.evict()
+}
+
+// acquire opens the segment if it is closed and holds it open until release is called
+func (p *segmentPool) acquire(s *segment) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s.removed {
+		return fmt.Errorf("log: segment %d has been removed", s.baseOffset)
+	}
+	if !s.isOpen() {
+		if _, err := s.open(); err != nil {
+			return err
+		}
+	}
+	p.refs[s]++
+	p.touch(s)
+	p.evict()
+	return nil
+}
+
+// release gives up a reference taken by acquire
+func (p *segmentPool) release(s *segment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refs[s]--; p.refs[s] <= 0 {
+		delete(p.refs, s)
+	}
+	p.evict()
+}
+
+// remove forgets the segment, it can't be acquired anymore afterwards
+func (p *segmentPool) remove(s *segment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.removed = true
+	if e, ok := p.elems[s]; ok {
+		p.lru.Remove(e)
+		delete(p.elems, s)
+	}
+	delete(p.refs, s)
+}
+
+// closeAll closes every open segment
+func (p *segmentPool) closeAll() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for e := p.lru.Front(); e != nil; e = p.lru.Front() {
+		s := p.lru.Remove(e).(*segment)
+		delete(p.elems, s)
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns the number of open segments
+func (p *segmentPool) open() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+func (p *segmentPool) touch(s *segment) {
+	if e, ok := p.elems[s]; ok {
+		p.lru.MoveToFront(e)
+		return
+	}
+	p.elems[s] = p.lru.PushFront(s)
+}
+
+// evict closes the least recently used segments that are not in use until the pool is within its
+// limit. Errors closing a segment only mean it stays open, reads don't depend on them.
+func (p *segmentPool) evict() {
+	if p.max <= 0 {
+		return
+	}
+	for e := p.lru.Back(); e != nil && p.lru.Len() > p.max; {
+		prev := e.Prev()
+		s := e.Value.(*segment)
+		if s != p.active && p.refs[s] == 0 {
+			if err := s.Close(); err == nil {
+				p.lru.Remove(e)
+				delete(p.elems, s)
+			}
+		}
+		e = prev
+	}
+}
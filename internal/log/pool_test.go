@@ -0,0 +1,131 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 10; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// only the active segment is opened when the log is set up
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, 6, len(log.segments))
+	require.Equal(t, 1, log.pool.open())
+
+	// cold segments are opened on demand, and the pool stays within its limit
+	for off := uint64(0); off < 10; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+		require.LessOrEqual(t, log.pool.open(), 2)
+	}
+	require.True(t, log.activeSegment.isOpen())
+
+	// concurrent readers each keep the segment they read from open
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for off := uint64(i); off < 10; off++ {
+				read, err := log.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, off, read.Offset)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.LessOrEqual(t, log.pool.open(), 2)
+
+	// the reader goes through every segment, opening them one at a time
+	b, err := ioutil.ReadAll(log.Reader())
+	require.NoError(t, err)
+	var records int
+	for len(b) > 0 {
+		b = b[lenWidth+enc.Uint64(b[:lenWidth]):]
+		records++
+	}
+	require.Equal(t, 10, records)
+	require.LessOrEqual(t, log.pool.open(), 2)
+
+	_, err = log.Read(10)
+	require.Error(t, err)
+	require.NoError(t, log.Close())
+}
+
+func TestReaderAfterRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// a reader that hasn't started yet doesn't open the files of a removed log again
+	reader := log.Reader()
+	require.NoError(t, log.Remove())
+	_, err = ioutil.ReadAll(reader)
+	require.ErrorIs(t, err, ErrLogClosed)
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSegmentPoolUnmaps(t *testing.T) {
+	if _, err := os.Stat("/proc/self/maps"); err != nil {
+		t.Skip("no /proc/self/maps to count the mappings in")
+	}
+	dir, err := ioutil.TempDir("", "pool-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 10; i++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// the indexes closed by the pool are unmapped, only the open segments and the standby segment
+	// have mappings
+	for pass := 0; pass < 5; pass++ {
+		for off := uint64(0); off < 10; off++ {
+			_, err := log.Read(off)
+			require.NoError(t, err)
+		}
+	}
+	maps, err := ioutil.ReadFile("/proc/self/maps")
+	require.NoError(t, err)
+	require.LessOrEqual(t, strings.Count(string(maps), dir+"/"), 3)
+}
@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package log
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, the space is allocated without changing the file size
+const fallocKeepSize = 0x01
+
+// preallocate reserves size bytes of disk space for the file, so appends to the store don't have
+// to allocate blocks as it grows. The size of the file is left as it is, the store takes its size
+// from it. File systems without fallocate support are not an error.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
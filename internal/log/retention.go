@@ -0,0 +1,66 @@
+package log
+
+// Retention removes the oldest segments once the log is bigger than Retention.MaxBytes, or once
+// they haven't been appended to for Retention.MaxAge. Whole segments are removed with Truncate, so
+// the log may keep up to a segment more than the limits, and the active segment is always kept.
+// The log doesn't apply it on its own, the server calls ApplyRetention periodically.
+
+import (
+	"os"
+	"time"
+)
+
+// ApplyRetention truncates the segments that are past the retention limits of the config, and
+// returns the lowest offset left in the log
+func (l *Log) ApplyRetention() (uint64, error) {
+	max, maxAge := l.Config.Retention.MaxBytes, l.Config.Retention.MaxAge
+	if max == 0 && maxAge == 0 {
+		return l.LowestOffset()
+	}
+
+	// the stores are looked at on disk, most segments are closed in the pool. The stores of the
+	// sealed segments were flushed when they were rolled, the active one may not be. They're
+	// looked at under the lock, so a concurrent Truncate can't remove them in the meantime.
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return 0, ErrLogClosed
+	}
+	segments := append([]*segment(nil), l.segments...)
+	active := l.activeSegment
+	infos := make([]os.FileInfo, len(segments))
+	var total uint64
+	for i, s := range segments {
+		fi, err := os.Stat(s.storePath())
+		if err != nil {
+			l.mu.RUnlock()
+			return 0, err
+		}
+		infos[i] = fi
+		total += uint64(fi.Size())
+	}
+	l.mu.RUnlock()
+
+	// segments are removed oldest first, the first segment that is kept keeps the ones after it
+	var truncate bool
+	var lowest uint64
+	now := time.Now()
+	for i, s := range segments {
+		if s == active || s.nextOffset == 0 {
+			break
+		}
+		tooBig := max > 0 && total > max
+		tooOld := maxAge > 0 && now.Sub(infos[i].ModTime()) > maxAge
+		if !tooBig && !tooOld {
+			break
+		}
+		total -= uint64(infos[i].Size())
+		truncate, lowest = true, s.nextOffset-1
+	}
+	if truncate {
+		if err := l.Truncate(lowest); err != nil {
+			return 0, err
+		}
+	}
+	return l.LowestOffset()
+}
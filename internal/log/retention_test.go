@@ -0,0 +1,91 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// every segment gets two records
+	for i := 0; i < 6; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 4)
+
+	// no limits, nothing is removed
+	lowest, err := log.ApplyRetention()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+
+	// the first segment hasn't been appended to for long
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(log.segments[0].storePath(), old, old))
+	log.Config.Retention.MaxAge = time.Hour
+	lowest, err = log.ApplyRetention()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+
+	// the stores take more than a segment's worth, only the active segment is left
+	log.Config.Retention.MaxAge = 0
+	log.Config.Retention.MaxBytes = 1
+	lowest, err = log.ApplyRetention()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), lowest)
+	require.Len(t, log.segments, 1)
+}
+
+func TestApplyRetentionTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.MaxAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 100; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// the segments truncated away from under retention aren't an error
+	done := make(chan error, 1)
+	go func() {
+		for off := uint64(1); off < 100; off += 2 {
+			if err := log.Truncate(off); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			return
+		default:
+		}
+		_, err := log.ApplyRetention()
+		require.NoError(t, err)
+	}
+}
@@ -9,6 +9,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 
@@ -16,55 +17,115 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// store and index are nil while the segment is closed, see pool.go
 type segment struct {
 	store                  *store
 	index                  *index
+	dir                    string
 	baseOffset, nextOffset uint64
 	config                 Config
+	removed                bool
+	// standby is true for a segment pre-created before its base offset is known, see standby.go
+	standby bool
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	// create a segement pointer
 	seg := &segment{
+		dir:        dir,
 		baseOffset: baseOffset,
 		config:     c,
 	}
-
 	var err error
+	if seg.nextOffset, err = seg.open(); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// closedSegment returns a segment whose files are opened later by the segment pool. A segment that
+// isn't the last one of the log ends where the next one starts, so its next offset is known
+// without reading its index.
+func closedSegment(dir string, baseOffset, nextOffset uint64, c Config) *segment {
+	return &segment{
+		dir:        dir,
+		baseOffset: baseOffset,
+		nextOffset: nextOffset,
+		config:     c,
+	}
+}
+
+func (seg *segment) storePath() string {
+	return path.Join(seg.dir, seg.fileName()+".store")
+}
+
+func (seg *segment) indexPath() string {
+	return path.Join(seg.dir, seg.fileName()+".index")
+}
+
+func (seg *segment) fileName() string {
+	if seg.standby {
+		return standbyName
+	}
+	return fmt.Sprintf("%d", seg.baseOffset)
+}
+
+func (seg *segment) isOpen() bool {
+	return seg.store != nil
+}
+
+// open opens, or creates, the store and index files of the segment and returns the next offset
+// found in the index. The next offset isn't set on the segment here, for a segment reopened by the
+// pool it's already known and may be read concurrently.
+func (seg *segment) open() (nextOffset uint64, err error) {
+	c := seg.config
+	baseOffset := seg.baseOffset
+
 	// create the store file if not present that's why added OS.O_CREATE FLAG
 
 	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
+		seg.storePath(),
 		os.O_RDWR|os.O_CREATE|os.O_APPEND,
 		0644,
 	)
 
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	// assigns the segement store field with the newstore pointer
-	if seg.store, err = newStore(storeFile); err != nil {
-		return nil, err
+	store, err := newStore(storeFile, c)
+	if err != nil {
+		storeFile.Close()
+		return 0, err
 	}
 
 	/// creates the index file if not present
 
 	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
+		seg.indexPath(),
 		os.O_RDWR|os.O_CREATE,
 		0644,
 	)
 
 	if err != nil {
-		return nil, err
+		store.Close()
+		return 0, err
 	}
 
 	// assigns the segment index field with the new index pointer
 
-	if seg.index, err = newIndex(indexFile, c); err != nil {
-		return nil, err
+	// the width of the index comes from the segment header, segments keep the width they were
+	// created with even if the config changes
+	ic := c
+	ic.Segment.WideIndex = store.wideIndex()
+	index, err := newIndex(indexFile, ic)
+	if err != nil {
+		indexFile.Close()
+		store.Close()
+		return 0, err
 	}
+	seg.store, seg.index = store, index
 
 	// setting the baseOffset, if the segment is empty then the next off set would be the baseOffset
 	// otherwise for the new offset, the next record should take the offset at the end of segment
@@ -74,11 +135,11 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	//  values are set relatively, then the segment's next offset value would be 12+3+1. Again, to get more
 	// clarification go index.go and look at write function to understand how the relative indexing is done.
 	if off, _, err := seg.index.Read(-1); err != nil {
-		seg.nextOffset = baseOffset
+		nextOffset = baseOffset
 	} else {
-		seg.nextOffset = baseOffset + uint64(off) + 1
+		nextOffset = baseOffset + off + 1
 	}
-	return seg, nil
+	return nextOffset, nil
 
 }
 
@@ -97,7 +158,7 @@ func (seg *segment) Append(record *api.Record) (offset uint64, err error) {
 	if err != nil {
 		return 0, err
 	}
-	if err = seg.index.Write(uint32(seg.nextOffset-seg.baseOffset), pos); err != nil {
+	if err = seg.index.Write(seg.nextOffset-seg.baseOffset, pos); err != nil {
 		return 0, err
 	}
 	seg.nextOffset++
@@ -112,6 +173,9 @@ func (seg *segment) Read(off uint64) (*api.Record, error) {
 
 	// first translates the absolute index into relative index
 	_, pos, err := seg.index.Read(int64(off - seg.baseOffset))
+	if err == io.EOF {
+		return nil, &OffsetOutOfRangeError{Offset: off}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -120,14 +184,31 @@ func (seg *segment) Read(off uint64) (*api.Record, error) {
 		return nil, err
 	}
 	record := &api.Record{}
-	err = proto.Unmarshal(p, record)
-	return record, err
+	if err = proto.Unmarshal(p, record); err != nil {
+		return nil, &CorruptError{Path: seg.storePath(), Pos: pos, Err: err}
+	}
+	return record, nil
 }
 
 // returns if the segment has reached its max size or not
 // the log uses the method to know if it needs to create a new segment
+// the index is full once it has no room for another entry, MaxIndexBytes needn't be a whole
+// number of entries
 func (seg *segment) IsMaxed() bool {
-	return seg.store.size >= seg.config.Segment.MaxStoreBytes || seg.index.size >= seg.config.Segment.MaxIndexBytes
+	return seg.store.size >= seg.config.Segment.MaxStoreBytes ||
+		seg.index.size+seg.index.entWidth > seg.config.Segment.MaxIndexBytes
+}
+
+// fill returns how full the segment is, from 0 to 1, by whichever of the store and index is fuller
+func (seg *segment) fill() float64 {
+	fill := float64(seg.store.size) / float64(seg.config.Segment.MaxStoreBytes)
+	if f := float64(seg.index.size) / float64(seg.config.Segment.MaxIndexBytes); f > fill {
+		fill = f
+	}
+	if fill > 1 {
+		return 1
+	}
+	return fill
 }
 
 // remove closed the segment, anbd removes the index and store files
@@ -136,24 +217,37 @@ func (seg *segment) Remove() error {
 	if err := seg.Close(); err != nil {
 		return err
 	}
-	if err := os.Remove(seg.index.Name()); err != nil {
+	if err := os.Remove(seg.indexPath()); err != nil {
 		return err
 	}
-	if err := os.Remove(seg.store.Name()); err != nil {
+	if err := os.Remove(seg.storePath()); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Sync flushes the store and syncs the store and index files to disk
+func (seg *segment) Sync() error {
+	if err := seg.store.Sync(); err != nil {
+		return err
+	}
+	return seg.index.Sync()
+}
+
 // to close the segement, that is close the store and index files
+// closing a closed segment does nothing
 
 func (seg *segment) Close() error {
+	if !seg.isOpen() {
+		return nil
+	}
 	if err := seg.index.Close(); err != nil {
 		return err
 	}
 	if err := seg.store.Close(); err != nil {
 		return err
 	}
+	seg.store, seg.index = nil, nil
 	return nil
 }
 
@@ -47,3 +47,30 @@ func TestSegment(t *testing.T) {
 	require.False(t, s.IsMaxed())
 
 }
+
+func TestSegmentWideIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-wide-test")
+	defer os.RemoveAll(dir)
+	want := &api.Record{Value: []byte("hello world")}
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = wideEntWidth * 3
+	c.Segment.WideIndex = true
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.True(t, s.store.wideIndex())
+
+	off, err := s.Append(want)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// the width comes from the segment header when the segment is opened again
+	c.Segment.WideIndex = false
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, wideEntWidth, s.index.entWidth)
+	got, err := s.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, want.Value, got.Value)
+	require.NoError(t, s.Remove())
+}
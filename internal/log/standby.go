@@ -0,0 +1,117 @@
+package log
+
+// Rolling the active segment used to happen inline in Append: the append that filled the segment
+// waited for two files to be created, the index to be truncated to MaxIndexBytes and mmapped.
+// Instead, a background goroutine keeps the next segment ready. As the base offset of the next
+// segment isn't known until the roll, the standby segment is created under the name standby.store
+// and standby.index, and its files are renamed once it's promoted to be the active segment. Only
+// one standby segment exists at a time, a new one is prepared after the previous one was taken.
+// When none is ready, because the roll came too soon or preparing it failed, Append creates the
+// segment inline like before.
+
+import (
+	"os"
+	"path"
+)
+
+const standbyName = "standby"
+
+type standbyPreparer struct {
+	dir    string
+	config Config
+	// ready hands the prepared segment over, it's unbuffered so the preparer never holds more
+	// than one segment
+	ready chan *segment
+	wake  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startStandby removes standby files left behind by an earlier run and starts preparing a segment
+func startStandby(dir string, c Config) (*standbyPreparer, error) {
+	for _, ext := range []string{".store", ".index"} {
+		if err := os.Remove(path.Join(dir, standbyName+ext)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	p := &standbyPreparer{
+		dir:    dir,
+		config: c,
+		ready:  make(chan *segment),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	p.kick()
+	return p, nil
+}
+
+func (p *standbyPreparer) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+		seg := &segment{dir: p.dir, config: p.config, standby: true}
+		if _, err := seg.open(); err != nil {
+			// the next roll creates its segment inline and wakes the preparer up again
+			continue
+		}
+		select {
+		case p.ready <- seg:
+		case <-p.stop:
+			_ = seg.Remove()
+			return
+		}
+	}
+}
+
+// kick asks for the next segment to be prepared
+func (p *standbyPreparer) kick() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the prepared segment with its files renamed after baseOffset, or nil if no segment
+// is ready
+func (p *standbyPreparer) take(baseOffset uint64) *segment {
+	defer p.kick()
+	select {
+	case seg := <-p.ready:
+		if err := seg.promote(baseOffset); err != nil {
+			_ = seg.Remove()
+			return nil
+		}
+		return seg
+	default:
+		return nil
+	}
+}
+
+// close stops the preparer and removes the segment it had ready
+func (p *standbyPreparer) close() {
+	close(p.stop)
+	<-p.done
+}
+
+// promote gives the standby segment its base offset and renames its files accordingly
+func (seg *segment) promote(baseOffset uint64) error {
+	oldStore, oldIndex := seg.storePath(), seg.indexPath()
+	seg.standby = false
+	seg.baseOffset, seg.nextOffset = baseOffset, baseOffset
+	if err := os.Rename(oldStore, seg.storePath()); err != nil {
+		seg.standby = true
+		return err
+	}
+	if err := os.Rename(oldIndex, seg.indexPath()); err != nil {
+		_ = os.Rename(seg.storePath(), oldStore)
+		seg.standby = true
+		return err
+	}
+	return nil
+}
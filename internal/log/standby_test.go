@@ -0,0 +1,65 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandbySegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "standby-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	standbyStore := path.Join(dir, standbyName+".store")
+	ready := func() bool {
+		_, err := os.Stat(standbyStore)
+		return err == nil
+	}
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Eventually(t, ready, time.Second, time.Millisecond)
+
+	// the roll promotes the standby segment and a new one gets prepared
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 2; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint64(2), log.activeSegment.baseOffset)
+	require.False(t, log.activeSegment.standby)
+	_, err = os.Stat(path.Join(dir, "2.store"))
+	require.NoError(t, err)
+	require.Eventually(t, ready, time.Second, time.Millisecond)
+
+	// the store file keeps the size of what's in it, preallocated space doesn't count
+	fi, err := os.Stat(path.Join(dir, "2.store"))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), fi.Size())
+
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+
+	// closing the log removes the standby segment
+	require.NoError(t, log.Close())
+	require.False(t, ready())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	for off := uint64(0); off < 6; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	require.NoError(t, log.Close())
+}
@@ -4,7 +4,11 @@ package log
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"sync"
 )
@@ -19,16 +23,50 @@ const (
 	lenWidth = 8
 )
 
+/*
+Two on-disk formats are supported for the store file.
+
+Version 1 has no header, every entry is an 8 byte big endian length followed by the record.
+
+Version 2 starts with an 8 byte segment header: the magic bytes "plog", the format version, a flags
+byte and two reserved bytes. Every entry is the length of the record as an unsigned varint, an
+attributes byte and the record. Records smaller than 128 bytes spend 2 bytes on framing instead of 8.
+The attributes byte is reserved for per record flags ( compression for example ) and is zero for now.
+
+The index file has the same layout in both versions, its fixed width entries are what allow an
+offset to be looked up without scanning, so the segment header in the store is what tells the
+version of the whole segment.
+*/
+const (
+	formatV1 uint8 = 1
+	formatV2 uint8 = 2
+
+	headerWidth = 8
+	// maxFrameWidth is the most bytes a v2 entry spends before the record itself
+	maxFrameWidth = binary.MaxVarintLen64 + 1
+
+	attrNone byte = 0
+
+	// flagWideIndex is set in the segment header when the index of the segment is a wide index
+	flagWideIndex uint8 = 1 << 0
+)
+
+var storeMagic = []byte("plog")
+
 // struct to have a pointer to a file, bufio writer, and the size of
 type store struct {
 	*os.File
-	mu   sync.Mutex
-	buf  *bufio.Writer
-	size uint64
+	mu      sync.Mutex
+	buf     *bufio.Writer
+	size    uint64
+	version uint8
+	flags   uint8
 }
 
 // newStore returns a pointer to a new store struct for given file
-func newStore(f *os.File) (*store, error) {
+// an empty file is set up with the format version from the config, otherwise the version is read
+// from the segment header of the file
+func newStore(f *os.File, c Config) (*store, error) {
 	// to get the file's current size
 	fi, err := os.Stat(f.Name())
 
@@ -37,12 +75,74 @@ func newStore(f *os.File) (*store, error) {
 	}
 
 	size := uint64(fi.Size())
-	return &store{
+	s := &store{
 		File: f,
 		size: size,
 		buf:  bufio.NewWriter(f),
-	}, nil
+	}
+	if size == 0 {
+		// preallocation only saves the appends some work, a failure doesn't stop the store
+		// from being used
+		if c.Segment.MaxStoreBytes > 0 {
+			_ = preallocate(f, int64(c.Segment.MaxStoreBytes))
+		}
+		s.version = c.formatVersion()
+		if s.version >= formatV2 {
+			if c.Segment.WideIndex {
+				s.flags |= flagWideIndex
+			}
+			header := make([]byte, headerWidth)
+			copy(header, storeMagic)
+			header[len(storeMagic)] = s.version
+			header[len(storeMagic)+1] = s.flags
+			if _, err = s.buf.Write(header); err != nil {
+				return nil, err
+			}
+			s.size = headerWidth
+		}
+		return s, nil
+	}
+
+	if s.version, s.flags, err = readHeader(f, size); err != nil {
+		return nil, err
+	}
+	if s.version > formatVersion {
+		return nil, fmt.Errorf(
+			"log: %s has format version %d, want at most %d",
+			f.Name(), s.version, formatVersion,
+		)
+	}
+	return s, nil
+}
+
+// wideIndex reports whether the segment of the store has a wide index
+func (s *store) wideIndex() bool {
+	return s.flags&flagWideIndex != 0
+}
+
+// readHeader returns the version and flags from the segment header of the store file, files
+// without a header are version 1 files. A version 1 file can't start with the magic bytes, it would
+// need a first record of more than 2^62 bytes for that.
+func readHeader(r io.ReaderAt, size uint64) (version, flags uint8, err error) {
+	if size < headerWidth {
+		return formatV1, 0, nil
+	}
+	header := make([]byte, headerWidth)
+	if _, err = r.ReadAt(header, 0); err != nil {
+		return 0, 0, err
+	}
+	if !bytes.Equal(header[:len(storeMagic)], storeMagic) {
+		return formatV1, 0, nil
+	}
+	return header[len(storeMagic)], header[len(storeMagic)+1], nil
+}
 
+// formatVersion returns the format version new segments are written with
+func (c Config) formatVersion() uint8 {
+	if c.Segment.FormatVersion == 0 {
+		return formatVersion
+	}
+	return c.Segment.FormatVersion
 }
 
 // function appends a p bytes into the buffer by using the buf field in the store struct
@@ -54,38 +154,98 @@ func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	pos = s.size
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
-		return 0, 0, err
+
+	var written int
+	if s.version == formatV1 {
+		if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+			return 0, 0, err
+		}
+		written = lenWidth
+	} else {
+		frame := make([]byte, maxFrameWidth)
+		w := binary.PutUvarint(frame, uint64(len(p)))
+		frame[w] = attrNone
+		if written, err = s.buf.Write(frame[:w+1]); err != nil {
+			return 0, 0, err
+		}
 	}
 
-	written, err := s.buf.Write(p)
+	w, err := s.buf.Write(p)
 	if err != nil {
 		return 0, 0, err
 	}
-	written += lenWidth
+	written += w
 	s.size += uint64(written)
 	return uint64(written), pos, nil
 }
 
+// entrySize returns how many bytes a record of n bytes takes in the store, framing included
+func (s *store) entrySize(n uint64) uint64 {
+	if s.version == formatV1 {
+		return lenWidth + n
+	}
+	frame := make([]byte, binary.MaxVarintLen64)
+	return uint64(binary.PutUvarint(frame, n)) + 1 + n
+}
+
 // function returns the record stored at the given post
 // it first flushed the buffer to the dist
 // then reades the record from the file onto an initialized slice of bytes of the required length
 
 func (s *store) Read(pos uint64) ([]byte, error) {
+	b, _, err := s.readEntry(pos)
+	return b, err
+}
+
+// readEntry returns the record stored at pos together with the position of the entry after it
+func (s *store) readEntry(pos uint64) ([]byte, uint64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.buf.Flush(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
-		return nil, err
+	// an entry the index points at but the store doesn't have has been cut off
+	if pos >= s.size {
+		return nil, 0, s.corrupt(pos, io.EOF)
 	}
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
-		return nil, err
+
+	var length, frameWidth uint64
+	if s.version == formatV1 {
+		size := make([]byte, lenWidth)
+		if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+			return nil, 0, s.corrupt(pos, err)
+		}
+		length, frameWidth = enc.Uint64(size), lenWidth
+	} else {
+		frame := make([]byte, maxFrameWidth)
+		n, err := s.File.ReadAt(frame, int64(pos))
+		if err != nil && !(errors.Is(err, io.EOF) && n > 0) {
+			return nil, 0, s.corrupt(pos, err)
+		}
+		l, w := binary.Uvarint(frame[:n])
+		if w <= 0 || w >= n {
+			return nil, 0, s.corrupt(pos, errors.New("invalid record frame"))
+		}
+		length, frameWidth = l, uint64(w)+1
+	}
+
+	if pos+frameWidth+length > s.size {
+		return nil, 0, s.corrupt(pos, fmt.Errorf("record of %d bytes runs past the end of the file", length))
+	}
+	b := make([]byte, length)
+	if _, err := s.File.ReadAt(b, int64(pos+frameWidth)); err != nil {
+		return nil, 0, s.corrupt(pos, err)
 	}
-	return b, nil
+	return b, pos + frameWidth + length, nil
+}
+
+// corrupt wraps the error decoding the entry at pos, running into the end of the file inside an
+// entry means it is corrupt as well
+func (s *store) corrupt(pos uint64, err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return &CorruptError{Path: s.Name(), Pos: pos, Err: err}
 }
 
 // FUnction reads len(p) bytes into p beginnng at the off offset in the stores's file
@@ -100,6 +260,65 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// reader returns an io.Reader over the records of the store in the version 1 framing, which is
+// what Log.Reader has always produced. Version 1 stores are read as they are, version 2 records are
+// re-framed one at a time.
+func (s *store) reader() io.Reader {
+	if s.version == formatV1 {
+		return &originReader{s, 0}
+	}
+	return &v1FrameReader{store: s, pos: headerWidth}
+}
+
+type v1FrameReader struct {
+	*store
+	pos     uint64
+	pending []byte
+}
+
+func (r *v1FrameReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.end() {
+			return 0, io.EOF
+		}
+		b, next, err := r.readEntry(r.pos)
+		if err != nil {
+			return 0, err
+		}
+		r.pending = make([]byte, lenWidth+len(b))
+		enc.PutUint64(r.pending, uint64(len(b)))
+		copy(r.pending[lenWidth:], b)
+		r.pos = next
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// end tells whether the reader has gone through every entry of the store
+func (r *v1FrameReader) end() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pos >= r.size
+}
+
+// flush writes the buffered appends to the file, without waiting for them to reach the disk
+func (s *store) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Flush()
+}
+
+// Sync flushes the buffered appends and syncs the file to disk
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.File.Sync()
+}
+
 // Close Method after ReadAt()
 func (s *store) Close() error {
 	s.mu.Lock()
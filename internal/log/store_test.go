@@ -11,8 +11,17 @@ import (
 var (
 	write = []byte("hello world")
 	width = uint64(len(write)) + lenWidth
+	// widthV2 is the width of a version 2 entry, a one byte varint length and the attributes byte
+	widthV2 = uint64(len(write)) + 2
 )
 
+// v1Config returns a config that writes new stores in the version 1 format
+func v1Config() Config {
+	c := Config{}
+	c.Segment.FormatVersion = formatV1
+	return c
+}
+
 //we create a store with a temporary file and call two test helpers to test appending and reading from the store. Then we create the store again
 // and test reading from it again to verify that our service will recover its state after a restart.
 func TestStoreAppendRead(t *testing.T) {
@@ -20,18 +29,60 @@ func TestStoreAppendRead(t *testing.T) {
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
 
-	s, err := newStore(f)
+	s, err := newStore(f, v1Config())
 	require.NoError(t, err)
 
 	testAppend(t, s)
 	testRead(t, s)
 	testReadAt(t, s)
 
-	s, err = newStore(f)
+	// the version comes from the file, not the config, once the store has been written to
+	s, err = newStore(f, Config{})
 	require.NoError(t, err)
+	require.Equal(t, formatV1, s.version)
 	testRead(t, s)
 }
 
+func TestStoreAppendReadV2(t *testing.T) {
+	f, err := ioutil.TempFile("", "store_append_read_v2_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+	require.Equal(t, formatV2, s.version)
+	require.Equal(t, uint64(headerWidth), s.size)
+
+	var pos uint64 = headerWidth
+	for i := uint64(1); i < 4; i++ {
+		n, p, err := s.Append(write)
+		require.NoError(t, err)
+		require.Equal(t, pos, p)
+		require.Equal(t, widthV2, n)
+		pos += n
+	}
+	for i, pos := uint64(1), uint64(headerWidth); i < 4; i++ {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+		pos += widthV2
+	}
+
+	// the reader keeps producing version 1 framing
+	b, err := ioutil.ReadAll(s.reader())
+	require.NoError(t, err)
+	require.Equal(t, int(width*3), len(b))
+	require.Equal(t, uint64(len(write)), enc.Uint64(b))
+	require.Equal(t, write, b[lenWidth:width])
+
+	s, err = newStore(f, v1Config())
+	require.NoError(t, err)
+	require.Equal(t, formatV2, s.version)
+	read, err := s.Read(headerWidth)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
 func testAppend(t *testing.T, s *store) {
 	t.Helper()
 	for i := uint64(1); i < 4; i++ {
@@ -74,7 +125,7 @@ func TestStoreClose(t *testing.T) {
 	f, err := ioutil.TempFile("", "store_close_test")
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
-	s, err := newStore(f)
+	s, err := newStore(f, v1Config())
 
 	require.NoError(t, err)
 	_, _, err = s.Append(write)
@@ -0,0 +1,46 @@
+package log
+
+// Readers that have caught up with the log can wait for the next record instead of polling.
+// appended is closed, and replaced by a new channel, every time records are appended or the log is
+// closed, so everyone waiting on it wakes up and checks the log again.
+
+import (
+	"context"
+)
+
+// WaitContext blocks until the log has a record at off, the log is closed, or ctx is done. It
+// returns right away when the record is already in the log, or has been truncated away.
+func (l *Log) WaitContext(ctx context.Context, off uint64) error {
+	for {
+		if err := rlockContext(ctx, l.mu); err != nil {
+			return err
+		}
+		closed, next, appended := l.closed, l.nextOffset(), l.appended
+		l.mu.RUnlock()
+		if closed {
+			return ErrLogClosed
+		}
+		if off < next {
+			return nil
+		}
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// nextOffset returns the offset the next record appended gets, l.mu must be held
+func (l *Log) nextOffset() uint64 {
+	if len(l.segments) == 0 {
+		return 0
+	}
+	return l.segments[len(l.segments)-1].nextOffset
+}
+
+// notify wakes up everyone waiting on the log, l.mu must be held for writing
+func (l *Log) notify() {
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-wait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+
+	record := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(record)
+	require.NoError(t, err)
+	// the record is there already
+	require.NoError(t, log.WaitContext(context.Background(), 0))
+
+	// gives up at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, log.WaitContext(ctx, 1))
+
+	// wakes up once the record is appended
+	done := make(chan error)
+	go func() { done <- log.WaitContext(context.Background(), 1) }()
+	time.Sleep(10 * time.Millisecond)
+	_, err = log.Append(record)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	// and when the log is closed
+	go func() { done <- log.WaitContext(context.Background(), 5) }()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, log.Close())
+	require.Equal(t, ErrLogClosed, <-done)
+}
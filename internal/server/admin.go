@@ -0,0 +1,161 @@
+package server
+
+// Admin endpoints, under /admin. Only the clients the Authorizer allows the admin action may use
+// them.
+//
+//	GET  /admin/segments    the segments of the log with their offsets and sizes
+//	GET  /admin/offsets     the lowest and highest offsets of the log
+//	POST /admin/truncate    removes the old segments, see TruncateRequest
+//	POST /admin/roll        starts a new active segment
+//	POST /admin/verify      checks the segments for inconsistencies between indexes and stores
+//
+// Segments, roll and verify need a log that implements Maintainer, they fail with 501 otherwise.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	plog "github.com/hamza-yusuff/proglog/internal/log"
+)
+
+// Maintainer is implemented by logs that can be looked into and maintained by the admin endpoints,
+// internal/log.Log implements it
+type Maintainer interface {
+	Segments() ([]plog.SegmentInfo, error)
+	Roll() (uint64, error)
+	Verify() ([]plog.Problem, error)
+}
+
+var errNotMaintainable = &statusError{
+	status: http.StatusNotImplemented,
+	code:   CodeNotImplemented,
+	err:    errors.New("the log doesn't support maintenance"),
+}
+
+// SegmentResponse describes a segment of the log
+type SegmentResponse struct {
+	BaseOffset uint64 `json:"base_offset"`
+	// NextOffset is the offset the next record appended to the segment would get
+	NextOffset    uint64 `json:"next_offset"`
+	StoreBytes    int64  `json:"store_bytes"`
+	IndexBytes    int64  `json:"index_bytes"`
+	Entries       uint64 `json:"entries"`
+	FormatVersion uint8  `json:"format_version"`
+	WideIndex     bool   `json:"wide_index"`
+	Active        bool   `json:"active"`
+}
+
+// SegmentsResponse lists the segments of the log, oldest first
+type SegmentsResponse struct {
+	Segments []SegmentResponse `json:"segments"`
+}
+
+// RollResponse is the base offset of the active segment after a roll
+type RollResponse struct {
+	ActiveBaseOffset uint64 `json:"active_base_offset"`
+}
+
+// VerifyResponse lists the problems verification found, OK is true when there are none
+type VerifyResponse struct {
+	OK       bool              `json:"ok"`
+	Problems []ProblemResponse `json:"problems"`
+}
+
+// ProblemResponse is an inconsistency found in a segment
+type ProblemResponse struct {
+	BaseOffset uint64 `json:"base_offset"`
+	Message    string `json:"message"`
+}
+
+func (server *httpServer) maintainer() (Maintainer, error) {
+	m, ok := server.Log.(Maintainer)
+	if !ok {
+		return nil, errNotMaintainable
+	}
+	return m, nil
+}
+
+func (server *httpServer) handleSegments(write http.ResponseWriter, r *http.Request) {
+	m, err := server.maintainer()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	segments, err := m.Segments()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	res := SegmentsResponse{Segments: []SegmentResponse{}}
+	for _, s := range segments {
+		res.Segments = append(res.Segments, SegmentResponse{
+			BaseOffset:    s.BaseOffset,
+			NextOffset:    s.NextOffset,
+			StoreBytes:    s.StoreBytes,
+			IndexBytes:    s.IndexBytes,
+			Entries:       s.Entries,
+			FormatVersion: s.FormatVersion,
+			WideIndex:     s.WideIndex,
+			Active:        s.Active,
+		})
+	}
+	writeJSON(write, res)
+}
+
+func (server *httpServer) handleRoll(write http.ResponseWriter, r *http.Request) {
+	m, err := server.maintainer()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	base, err := m.Roll()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	writeJSON(write, RollResponse{ActiveBaseOffset: base})
+}
+
+func (server *httpServer) handleVerify(write http.ResponseWriter, r *http.Request) {
+	m, err := server.maintainer()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	problems, err := m.Verify()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	res := VerifyResponse{OK: len(problems) == 0, Problems: []ProblemResponse{}}
+	for _, p := range problems {
+		res.Problems = append(res.Problems, ProblemResponse{BaseOffset: p.BaseOffset, Message: p.Message})
+	}
+	writeJSON(write, res)
+}
+
+// TruncateRequest asks for the segments with no records above Lowest to be removed, the record at
+// Lowest goes with its segment
+type TruncateRequest struct {
+	Lowest uint64 `json:"lowest"`
+}
+
+// handleTruncate truncates the log and sends its offsets afterwards
+func (server *httpServer) handleTruncate(write http.ResponseWriter, r *http.Request) {
+	var req TruncateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(write, badRequest(err))
+		return
+	}
+	if err := server.Log.Truncate(req.Lowest); err != nil {
+		writeError(write, err)
+		return
+	}
+	res, err := server.offsets()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	writeJSON(write, res)
+}
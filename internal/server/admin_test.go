@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	h, clog, teardown := setupTest(t, func(c *Config) { c.OpenAdmin = true })
+	defer teardown()
+
+	for i := 0; i < 3; i++ {
+		w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var roll RollResponse
+	w := do(t, h, "POST", "/admin/roll", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&roll))
+	require.Equal(t, RollResponse{ActiveBaseOffset: 3}, roll)
+
+	var segments SegmentsResponse
+	w = do(t, h, "GET", "/admin/segments", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&segments))
+	require.Len(t, segments.Segments, 2)
+	require.Equal(t, uint64(0), segments.Segments[0].BaseOffset)
+	require.Equal(t, uint64(3), segments.Segments[0].NextOffset)
+	require.Equal(t, uint64(3), segments.Segments[0].Entries)
+	require.False(t, segments.Segments[0].Active)
+	require.True(t, segments.Segments[1].Active)
+
+	var offsets OffsetsResponse
+	w = do(t, h, "GET", "/admin/offsets", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&offsets))
+	require.Equal(t, OffsetsResponse{Lowest: 0, Highest: 2}, offsets)
+
+	var verify VerifyResponse
+	w = do(t, h, "POST", "/admin/verify", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&verify))
+	require.Equal(t, VerifyResponse{OK: true, Problems: []ProblemResponse{}}, verify)
+
+	// a store that lost its last record is found
+	store := clog.Dir + "/0.store"
+	fi, err := os.Stat(store)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(store, fi.Size()-1))
+	w = do(t, h, "POST", "/admin/verify", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&verify))
+	require.False(t, verify.OK)
+	require.NotEmpty(t, verify.Problems)
+	require.Equal(t, uint64(0), verify.Problems[0].BaseOffset)
+}
+
+// commitLogOnly hides the methods of the log that aren't in CommitLog
+type commitLogOnly struct {
+	CommitLog
+}
+
+func TestAdminNotImplemented(t *testing.T) {
+	h, _, teardown := setupTest(t, func(c *Config) {
+		c.CommitLog = commitLogOnly{c.CommitLog}
+		c.OpenAdmin = true
+	})
+	defer teardown()
+
+	for _, w := range []*httptest.ResponseRecorder{
+		do(t, h, "GET", "/admin/segments", nil),
+		do(t, h, "POST", "/admin/roll", nil),
+		do(t, h, "POST", "/admin/verify", nil),
+	} {
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+		require.Contains(t, w.Body.String(), CodeNotImplemented)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+// Authorization of the requests, the identity of the client (see identity.go) has to be allowed the
+// action by the Authorizer of the config. Without an Authorizer every request is allowed, except
+// for the admin endpoints and /metrics: they take an identified client and an Authorizer that
+// allows it admin, unless Config.OpenAdmin opens them to everyone.
+// Denied requests are logged with the subject and the action, at most one line a second so
+// anyone can't flood the logs with them, the lines left out are counted in the next one. The HTTP
+// server counts all of them in proglog_http_denied_total as well.
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hamza-yusuff/proglog/internal/auth"
+)
+
+// Authorizer decides whether the subject may do the action on the object, internal/auth.Authorizer
+// implements it
+type Authorizer interface {
+	Authorize(subject, object, action string) error
+}
+
+// logObject is the name the server's log goes by in the policy
+const logObject = "default"
+
+// authorize checks the client of ctx may do the action, denied requests are logged
+func authorize(a Authorizer, ctx context.Context, action string) error {
+	if a == nil {
+		return nil
+	}
+	subject := Subject(ctx)
+	err := a.Authorize(subject, logObject, action)
+	if err != nil {
+		denials.log(subject, action, err)
+	}
+	return err
+}
+
+// denials logs the denied requests of every server of the process
+var denials = &denialLog{every: time.Second, now: time.Now, out: log.Default()}
+
+// denialLog logs a denial every so often, and counts the ones in between
+type denialLog struct {
+	mu      sync.Mutex
+	every   time.Duration
+	now     func() time.Time
+	out     *log.Logger
+	last    time.Time
+	dropped int
+}
+
+func (d *denialLog) log(subject, action string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if !d.last.IsZero() && now.Sub(d.last) < d.every {
+		d.dropped++
+		return
+	}
+	if d.dropped > 0 {
+		d.out.Printf("server: denied %s to %q: %v (%d more denied since the last line)", action, subject, err, d.dropped)
+	} else {
+		d.out.Printf("server: denied %s to %q: %v", action, subject, err)
+	}
+	d.last, d.dropped = now, 0
+}
+
+var (
+	errAdminAnonymous = &statusError{
+		status: http.StatusUnauthorized,
+		code:   CodeUnauthenticated,
+		err:    errors.New("admin requires an identified client"),
+	}
+	errAdminNoPolicy = &statusError{
+		status: http.StatusForbidden,
+		code:   CodePermissionDenied,
+		err:    errors.New("admin requires an access control policy"),
+	}
+)
+
+// authorizeAdmin checks the client may use the admin endpoints before the Authorizer is asked
+func (server *httpServer) authorizeAdmin(ctx context.Context) error {
+	if server.openAdmin {
+		return nil
+	}
+	if Subject(ctx) == "" {
+		return errAdminAnonymous
+	}
+	if server.authorizer == nil {
+		return errAdminNoPolicy
+	}
+	return nil
+}
+
+// authorizeAction is authorize with the denials counted in the metrics
+func (server *httpServer) authorizeAction(ctx context.Context, action string) error {
+	var err error
+	if action == auth.ActionAdmin {
+		err = server.authorizeAdmin(ctx)
+	}
+	if err == nil {
+		err = authorize(server.authorizer, ctx, action)
+	}
+	if err != nil {
+		server.metrics.denied.WithLabelValues(action).Inc()
+	}
+	return err
+}
+
+// authorized refuses the requests whose client may not do the action with 403
+func (server *httpServer) authorized(action string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := server.authorizeAction(r.Context(), action); err != nil {
+				writeError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorization(t *testing.T) {
+	authorizer, err := auth.New(auth.Policy{Rules: []auth.Rule{
+		{Subject: "alice", Actions: []string{auth.ActionProduce, auth.ActionConsume}},
+		{Subject: "bob", Actions: []string{auth.ActionConsume}},
+	}})
+	require.NoError(t, err)
+	h, _, teardown := setupTest(t, func(c *Config) { c.Authorizer = authorizer })
+	defer teardown()
+
+	as := func(subject, method, target string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, jsonBody(t, body))
+		if subject != "" {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{Subject: pkix.Name{CommonName: subject}},
+			}}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	produce := ProduceRequest{Record: Record{Value: []byte("hello world")}}
+	require.Equal(t, http.StatusOK, as("alice", "POST", "/", produce).Code)
+	require.Equal(t, http.StatusOK, as("bob", "GET", "/", ConsumeRequest{Offset: 0}).Code)
+
+	for _, w := range []*httptest.ResponseRecorder{
+		as("bob", "POST", "/", produce),
+		as("", "GET", "/", ConsumeRequest{Offset: 0}),
+		as("alice", "GET", "/admin/quotas", nil),
+		as("alice", "POST", "/admin/roll", nil),
+		as("alice", "GET", "/metrics", nil),
+	} {
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), CodePermissionDenied)
+	}
+}
+
+func TestDenialLog(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Unix(0, 0)
+	d := &denialLog{every: time.Second, now: func() time.Time { return now }, out: log.New(&buf, "", 0)}
+	denied := errors.New("permission denied")
+
+	// the denials within a second of a line aren't logged, the next line counts them
+	d.log("bob", "produce", denied)
+	d.log("bob", "produce", denied)
+	d.log("", "admin", denied)
+	now = now.Add(time.Second)
+	d.log("alice", "admin", denied)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, []string{
+		`server: denied produce to "bob": permission denied`,
+		`server: denied admin to "alice": permission denied (2 more denied since the last line)`,
+	}, lines)
+}
+
+func TestAdminWithoutPolicy(t *testing.T) {
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+	open, _, teardownOpen := setupTest(t, func(c *Config) { c.OpenAdmin = true })
+	defer teardownOpen()
+
+	as := func(h http.Handler, subject, method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if subject != "" {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{Subject: pkix.Name{CommonName: subject}},
+			}}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// without a policy the admin endpoints aren't anyone's, produce and consume are everyone's
+	for _, route := range [][3]string{
+		{"POST", "/admin/truncate", `{"lowest": 0}`},
+		{"POST", "/admin/roll"},
+		{"POST", "/admin/verify"},
+		{"GET", "/admin/quotas"},
+		{"GET", "/metrics"},
+	} {
+		w := as(h, "", route[0], route[1], route[2])
+		require.Equal(t, http.StatusUnauthorized, w.Code, route[1])
+		require.Contains(t, w.Body.String(), CodeUnauthenticated)
+		w = as(h, "alice", route[0], route[1], route[2])
+		require.Equal(t, http.StatusForbidden, w.Code, route[1])
+		require.Contains(t, w.Body.String(), CodePermissionDenied)
+		// unless they are opened to everyone
+		require.Equal(t, http.StatusOK, as(open, "", route[0], route[1], route[2]).Code, route[1])
+	}
+	require.Equal(t, http.StatusOK, as(h, "", "GET", "/offsets", "").Code)
+}
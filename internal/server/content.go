@@ -0,0 +1,209 @@
+package server
+
+// Content negotiation of the HTTP API. Besides JSON, the bodies can be protobuf, with the messages
+// of api/v1 that mirror the JSON ones, so binary values don't have to go through base64:
+//
+//	POST /                  api.ProduceRequest -> api.ProduceResponse
+//	GET  /                  api.ConsumeRequest -> api.ConsumeResponse
+//	GET  /records/{offset}  api.Record
+//	GET  /records           api.RecordsResponse
+//
+// A produce request can also send the value alone as application/octet-stream, and the routes
+// that send one record send its value alone when the client accepts application/octet-stream,
+// with the offset in the X-Record-Offset header.
+// The request body is picked by Content-Type, anything but protobuf and octet-stream is read as
+// JSON like it always was. The response is picked by Accept, a client that doesn't say gets the
+// type it sent, and errors are always JSON, see errors.go.
+// Bodies are read with a limit sized from the log's MaxRecordBytes, a body too big to hold a record
+// the log would take is refused with 413 before it's all in memory.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentJSON        = "application/json"
+	contentProtobuf    = "application/x-protobuf"
+	contentOctetStream = "application/octet-stream"
+
+	// offsetHeader is the offset of a record sent as a raw value
+	offsetHeader = "X-Record-Offset"
+
+	// bodySlack is what a body may have besides the record's value, which base64 makes a third
+	// bigger in JSON
+	bodySlack = 4 << 10
+	// defaultMaxBodyBytes bounds the bodies when the log doesn't limit the size of records
+	defaultMaxBodyBytes = 64 << 20
+)
+
+// RecordLimiter is implemented by logs that limit the size of records, internal/log.Log implements
+// it
+type RecordLimiter interface {
+	MaxRecordBytes() uint64
+}
+
+// maxBodyBytes returns the size of the biggest body that can hold a record the log takes
+func maxBodyBytes(l CommitLog) int64 {
+	if rl, ok := l.(RecordLimiter); ok && rl.MaxRecordBytes() > 0 {
+		return int64(rl.MaxRecordBytes())*4/3 + bodySlack
+	}
+	return defaultMaxBodyBytes
+}
+
+var errBodyTooLarge = &statusError{
+	status: http.StatusRequestEntityTooLarge,
+	code:   CodeRecordTooLarge,
+	err:    errors.New("request body too large"),
+}
+
+// limitedBody is a body read through http.MaxBytesReader that tells its limit apart from the
+// other errors reading it
+type limitedBody struct {
+	io.ReadCloser
+	max, n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && err != io.EOF && b.n >= b.max {
+		err = errBodyTooLarge
+	}
+	return n, err
+}
+
+// limitBody replaces the body of r with one that fails with errBodyTooLarge past the limit
+func (server *httpServer) limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, server.maxBody), max: server.maxBody}
+}
+
+// bodyError is the error of reading a body, the client's fault unless the body was too large
+func bodyError(err error) error {
+	if errors.Is(err, errBodyTooLarge) {
+		return err
+	}
+	return badRequest(err)
+}
+
+// contentType returns the type of the request body, JSON unless it's protobuf or octet-stream
+func contentType(r *http.Request) string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && (mt == contentProtobuf || mt == contentOctetStream) {
+		return mt
+	}
+	return contentJSON
+}
+
+func unsupportedMediaType(typ string) error {
+	return &statusError{
+		status: http.StatusUnsupportedMediaType,
+		code:   CodeUnsupportedMediaType,
+		err:    fmt.Errorf("unsupported content type %s", typ),
+	}
+}
+
+// readBody decodes the request body into v when it's JSON, or into m when it's protobuf
+func readBody(r *http.Request, v interface{}, m proto.Message) error {
+	switch typ := contentType(r); typ {
+	case contentJSON:
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return bodyError(err)
+		}
+	case contentProtobuf:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return bodyError(err)
+		}
+		if err = proto.Unmarshal(b, m); err != nil {
+			return badRequest(err)
+		}
+	default:
+		return unsupportedMediaType(typ)
+	}
+	return nil
+}
+
+// responseType returns the type of the offers the client prefers by its Accept header. JSON and
+// protobuf are always offered, a client that accepts none of the offers gets the type it sent.
+func responseType(r *http.Request, offers ...string) string {
+	def := contentJSON
+	if contentType(r) == contentProtobuf {
+		def = contentProtobuf
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return def
+	}
+	// the type the client sent goes first, for wildcards
+	offers = append([]string{def, contentJSON, contentProtobuf}, offers...)
+	best, bestQ := def, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaMatch(mt, offer) {
+				best, bestQ = offer, q
+				break
+			}
+		}
+	}
+	return best
+}
+
+// mediaMatch tells whether the media range of an Accept header, like text/*, matches typ
+func mediaMatch(rng, typ string) bool {
+	if rng == "*/*" || rng == typ {
+		return true
+	}
+	return strings.HasSuffix(rng, "/*") && strings.HasPrefix(typ, strings.TrimSuffix(rng, "*"))
+}
+
+// writeResponse writes v as the JSON body of the response, or m as the protobuf one, in the type
+// the client accepts
+func writeResponse(write http.ResponseWriter, r *http.Request, v interface{}, m proto.Message) {
+	if responseType(r) != contentProtobuf {
+		writeJSON(write, v)
+		return
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	write.Header().Set("Content-Type", contentProtobuf)
+	write.Write(b)
+}
+
+// writeRecordResponse is writeResponse for the routes that send one record, which send the
+// record's value alone to a client that accepts octet-stream
+func writeRecordResponse(write http.ResponseWriter, r *http.Request, record *api.Record, v interface{}, m proto.Message) {
+	if responseType(r, contentOctetStream) != contentOctetStream {
+		writeResponse(write, r, v, m)
+		return
+	}
+	write.Header().Set("Content-Type", contentOctetStream)
+	write.Header().Set(offsetHeader, strconv.FormatUint(record.Offset, 10))
+	write.Write(record.Value)
+}
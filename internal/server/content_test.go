@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	send := func(method, target, contentType, accept string, body io.Reader) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, body)
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	protoBody := func(m proto.Message) io.Reader {
+		t.Helper()
+		b, err := proto.Marshal(m)
+		require.NoError(t, err)
+		return bytes.NewReader(b)
+	}
+	requireProto := func(w *httptest.ResponseRecorder, m proto.Message) {
+		t.Helper()
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, contentProtobuf, w.Header().Get("Content-Type"))
+		require.NoError(t, proto.Unmarshal(w.Body.Bytes(), m))
+	}
+	value := []byte{0, 1, 2, 0xff}
+
+	// a protobuf request gets a protobuf response
+	produced := &api.ProduceResponse{}
+	w := send("POST", "/", contentProtobuf, "", protoBody(&api.ProduceRequest{Record: &api.Record{Value: value}}))
+	requireProto(w, produced)
+	require.Equal(t, uint64(0), produced.Offset)
+
+	// a raw value gets a JSON response unless the client accepts protobuf
+	w = send("POST", "/", contentOctetStream, "", bytes.NewReader(value))
+	require.Equal(t, http.StatusOK, w.Code)
+	var res ProduceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, uint64(1), res.Offset)
+	w = send("POST", "/", contentOctetStream, contentProtobuf, bytes.NewReader(value))
+	requireProto(w, produced)
+	require.Equal(t, uint64(2), produced.Offset)
+
+	consumed := &api.ConsumeResponse{}
+	requireProto(send("GET", "/", contentProtobuf, "", protoBody(&api.ConsumeRequest{Offset: 1})), consumed)
+	require.Equal(t, value, consumed.Record.Value)
+	require.Equal(t, uint64(1), consumed.Record.Offset)
+
+	record := &api.Record{}
+	requireProto(send("GET", "/records/2", "", "application/json;q=0.5, application/x-protobuf", nil), record)
+	require.Equal(t, value, record.Value)
+
+	w = send("GET", "/records/1", "", contentOctetStream, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, contentOctetStream, w.Header().Get("Content-Type"))
+	require.Equal(t, "1", w.Header().Get(offsetHeader))
+	require.Equal(t, value, w.Body.Bytes())
+
+	page := &api.RecordsResponse{}
+	requireProto(send("GET", "/records?from=1", "", contentProtobuf, nil), page)
+	require.Len(t, page.Records, 2)
+	require.Equal(t, uint64(3), page.Next)
+
+	// a page can't be a raw value, nor can a consume request, wildcards get JSON
+	w = send("GET", "/records?from=1", "", contentOctetStream, nil)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	w = send("GET", "/records/0", "", "*/*", nil)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	w = send("GET", "/", contentOctetStream, "", bytes.NewReader(value))
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	require.Contains(t, w.Body.String(), CodeUnsupportedMediaType)
+
+	// errors are JSON whatever the client accepts
+	w = send("GET", "/records/9", "", contentProtobuf, nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
+
+func TestBodyLimit(t *testing.T) {
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	// a body too big for a record of the log's MaxRecordBytes isn't read to its end
+	big, err := proto.Marshal(&api.ProduceRequest{Record: &api.Record{Value: make([]byte, 8<<10)}})
+	require.NoError(t, err)
+	bigJSON, err := json.Marshal(ProduceRequest{Record: Record{Value: make([]byte, 8<<10)}})
+	require.NoError(t, err)
+	for typ, body := range map[string][]byte{contentOctetStream: big, contentProtobuf: big, contentJSON: bigJSON} {
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", typ)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code, typ)
+		require.Contains(t, w.Body.String(), CodeRecordTooLarge)
+	}
+
+	// a record at the limit still fits, base64 and all
+	w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: make([]byte, 1000)}})
+	require.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,94 @@
+package server
+
+// Errors are sent to the client as a JSON body with the message and a code that doesn't change
+// with the wording of the message, e.g.
+//
+//	{"error": "log: offset out of range: 3", "code": "offset_out_of_range"}
+//
+// The status and code come from the errors of internal/log, so the layers agree on what went wrong.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	plog "github.com/hamza-yusuff/proglog/internal/log"
+)
+
+// Error codes of the error responses
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnauthenticated      = "unauthenticated"
+	CodePermissionDenied     = "permission_denied"
+	CodeOffsetOutOfRange     = "offset_out_of_range"
+	CodeRecordTooLarge       = "record_too_large"
+	CodeLogClosed            = "log_closed"
+	CodeCorrupt              = "corrupt_data"
+	CodeRateLimited          = "rate_limited"
+	CodeTimeout              = "timeout"
+	CodeCanceled             = "canceled"
+	CodeNotImplemented       = "not_implemented"
+	CodeInternal             = "internal"
+)
+
+// ErrOffsetNotFound is the error of reads past the end of the log, the one internal/log returns
+var ErrOffsetNotFound = plog.ErrOffsetOutOfRange
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// statusError is an error that carries its own status and code, for the errors that don't come
+// from the log
+type statusError struct {
+	status int
+	code   string
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// badRequest marks err as the client's fault
+func badRequest(err error) error {
+	return &statusError{status: http.StatusBadRequest, code: CodeBadRequest, err: err}
+}
+
+// errorStatus returns the status code and error code for err. A request that ran out of time gets
+// 504, one whose client went away gets 503, the client won't see it anyway.
+func errorStatus(err error) (int, string) {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return se.status, se.code
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return http.StatusForbidden, CodePermissionDenied
+	case errors.Is(err, plog.ErrOffsetOutOfRange):
+		return http.StatusNotFound, CodeOffsetOutOfRange
+	case errors.Is(err, plog.ErrRecordTooLarge):
+		return http.StatusRequestEntityTooLarge, CodeRecordTooLarge
+	case errors.Is(err, plog.ErrLogClosed):
+		return http.StatusServiceUnavailable, CodeLogClosed
+	case errors.Is(err, plog.ErrCorrupt):
+		return http.StatusInternalServerError, CodeCorrupt
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, CodeTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, CodeCanceled
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// writeError writes the error response for err
+func writeError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Code: code})
+}
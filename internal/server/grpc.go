@@ -0,0 +1,133 @@
+package server
+
+// gRPC flavour of the server, the Log service of api/v1/log.proto over the same commit log as the
+// HTTP server. ConsumeStream follows the log: once it has sent the last record it waits for the
+// next one to be appended, until the client goes away.
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	plog "github.com/hamza-yusuff/proglog/internal/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// NewGRPCServer returns a gRPC server with the Log service registered, opts are passed on to
+// grpc.NewServer
+func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	if config.TLSConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config.TLSConfig)))
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(identifyUnary),
+		grpc.ChainStreamInterceptor(identifyStream),
+	)
+	gsrv := grpc.NewServer(opts...)
+	api.RegisterLogServer(gsrv, newGRPCServer(config))
+	return gsrv, nil
+}
+
+type grpcServer struct {
+	api.UnimplementedLogServer
+	Log        CommitLog
+	Authorizer Authorizer
+}
+
+func newGRPCServer(config *Config) *grpcServer {
+	return &grpcServer{Log: config.CommitLog, Authorizer: config.Authorizer}
+}
+
+func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if err := authorize(s.Authorizer, ctx, auth.ActionProduce); err != nil {
+		return nil, grpcError(err)
+	}
+	if req.Record == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing record")
+	}
+	off, err := s.Log.AppendContext(ctx, req.Record)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.ProduceResponse{Offset: off}, nil
+}
+
+func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	if err := authorize(s.Authorizer, ctx, auth.ActionConsume); err != nil {
+		return nil, grpcError(err)
+	}
+	record, err := s.Log.ReadContext(ctx, req.Offset)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.ConsumeResponse{Record: record}, nil
+}
+
+// ProduceStream appends the records of the stream until the client closes its side
+func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := s.Produce(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// ConsumeStream sends the records from the requested offset on, waiting for the log to get the
+// next one when it has caught up
+func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	ctx := stream.Context()
+	if err := authorize(s.Authorizer, ctx, auth.ActionConsume); err != nil {
+		return grpcError(err)
+	}
+	for off := req.Offset; ; off++ {
+		if err := s.Log.WaitContext(ctx, off); err != nil {
+			return grpcError(err)
+		}
+		res, err := s.Consume(ctx, &api.ConsumeRequest{Offset: off})
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcError converts an error of the log to a status with the matching code, like errorStatus
+// does for HTTP
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, auth.ErrPermissionDenied):
+		code = codes.PermissionDenied
+	case errors.Is(err, plog.ErrOffsetOutOfRange):
+		code = codes.OutOfRange
+	case errors.Is(err, plog.ErrRecordTooLarge):
+		code = codes.InvalidArgument
+	case errors.Is(err, plog.ErrLogClosed):
+		code = codes.Unavailable
+	case errors.Is(err, plog.ErrCorrupt):
+		code = codes.DataLoss
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
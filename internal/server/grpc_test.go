@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	"github.com/hamza-yusuff/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupGRPCTest(t *testing.T, fn func(*Config)) (api.LogClient, func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "grpc-server-test")
+	require.NoError(t, err)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	config := &Config{CommitLog: clog}
+	if fn != nil {
+		fn(config)
+	}
+	srv, err := NewGRPCServer(config)
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	return api.NewLogClient(cc), func() {
+		cc.Close()
+		srv.Stop()
+		clog.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGRPCProduceConsume(t *testing.T) {
+	client, teardown := setupGRPCTest(t, nil)
+	defer teardown()
+	ctx := context.Background()
+
+	want := &api.Record{Value: []byte("hello world")}
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, produce.Offset, consume.Record.Offset)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset + 1})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func TestGRPCStreams(t *testing.T) {
+	client, teardown := setupGRPCTest(t, nil)
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	records := []*api.Record{
+		{Value: []byte("first message")},
+		{Value: []byte("second message")},
+	}
+	produce, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	for i, record := range records {
+		require.NoError(t, produce.Send(&api.ProduceRequest{Record: record}))
+		res, err := produce.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	require.NoError(t, produce.CloseSend())
+
+	consume, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	for i, record := range records {
+		res, err := consume.Recv()
+		require.NoError(t, err)
+		require.Equal(t, record.Value, res.Record.Value)
+		require.Equal(t, uint64(i), res.Record.Offset)
+	}
+
+	// the stream waits for the next record
+	next := &api.Record{Value: []byte("third message")}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Produce(ctx, &api.ProduceRequest{Record: next})
+	}()
+	res, err := consume.Recv()
+	require.NoError(t, err)
+	require.Equal(t, next.Value, res.Record.Value)
+	require.Equal(t, uint64(2), res.Record.Offset)
+}
+
+func TestGRPCAuthorization(t *testing.T) {
+	authorizer, err := auth.New(auth.Policy{Rules: []auth.Rule{
+		{Subject: auth.Wildcard, Actions: []string{auth.ActionConsume}},
+	}})
+	require.NoError(t, err)
+	client, teardown := setupGRPCTest(t, func(c *Config) { c.Authorizer = authorizer })
+	defer teardown()
+	ctx := context.Background()
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
@@ -0,0 +1,84 @@
+package server
+
+// Health endpoints for the probes of load balancers and orchestrators:
+//
+//	GET /healthz    200 while the log is open, the server is alive
+//	GET /readyz     200 while the log is open and can be appended to, the server should get traffic
+//
+// and 503 otherwise, with the reason. The log is only asked when it implements HealthChecker,
+// otherwise it's taken to be healthy.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	plog "github.com/hamza-yusuff/proglog/internal/log"
+)
+
+// HealthChecker is implemented by logs that can tell whether records can be appended to them,
+// internal/log.Log implements it
+type HealthChecker interface {
+	Writable() error
+}
+
+// HealthResponse is the body of the health endpoints
+type HealthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (server *httpServer) handleHealthz(write http.ResponseWriter, r *http.Request) {
+	err := server.writable()
+	// a log that can't be appended to can still be read from
+	if !errors.Is(err, plog.ErrLogClosed) {
+		err = nil
+	}
+	writeHealth(write, err)
+}
+
+func (server *httpServer) handleReadyz(write http.ResponseWriter, r *http.Request) {
+	writeHealth(write, server.writable())
+}
+
+func (server *httpServer) writable() error {
+	if hc, ok := server.Log.(HealthChecker); ok {
+		return hc.Writable()
+	}
+	return nil
+}
+
+func writeHealth(write http.ResponseWriter, err error) {
+	write.Header().Set("Content-Type", "application/json")
+	write.Header().Set("Cache-Control", "no-store")
+	res := HealthResponse{Status: "ok"}
+	if err != nil {
+		res = HealthResponse{Status: "unavailable", Error: err.Error()}
+		write.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(write, res)
+}
+
+// stopStreams ends the streams, and keeps new WebSocket connections from being counted
+func (server *httpServer) stopStreams() {
+	server.shutdownOnce.Do(func() {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		close(server.shutdown)
+	})
+}
+
+// streamContext returns a context that is done when the request's is, or when the server starts
+// shutting down. http.Server.Shutdown waits for the requests in flight, the streams that follow the
+// log would keep it waiting until its deadline.
+func (server *httpServer) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-server.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hamza-yusuff/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+
+	requireHealth := func(target string, status int, want string) {
+		t.Helper()
+		w := do(t, h, "GET", target, nil)
+		require.Equal(t, status, w.Code)
+		var res HealthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, want, res.Status)
+	}
+	requireHealth("/healthz", http.StatusOK, "ok")
+	requireHealth("/readyz", http.StatusOK, "ok")
+
+	require.NoError(t, clog.Close())
+	requireHealth("/healthz", http.StatusServiceUnavailable, "unavailable")
+	requireHealth("/readyz", http.StatusServiceUnavailable, "unavailable")
+}
+
+func TestShutdownEndsStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	srv, err := NewHTTPServer(":0", &Config{CommitLog: clog})
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/records/stream?from=0")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the stream is waiting for a record, shutting down ends it instead of waiting for the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	_, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+}
+
+func TestShutdownWaitsForWebSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	srv, err := NewHTTPServer(":0", &Config{CommitLog: clog})
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "1", Value: []byte("hello")}))
+	var msg WSMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, wsAck, msg.Type)
+
+	// Shutdown returns with the connection still open, WaitWebSockets waits for it to be closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	require.NoError(t, WaitWebSockets(ctx, srv))
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+
+	require.Error(t, WaitWebSockets(ctx, &http.Server{}))
+}
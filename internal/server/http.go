@@ -1,17 +1,69 @@
 package server
 
-// File responsiblef for implementing a JSON/HTTP web server
+// File responsiblef for implementing a JSON/HTTP web server, see grpc.go for the gRPC one
 // Has two endpoints ->
 // Produce for writing to the log
 // Consume for reading from the log
+// the bodies can be protobuf as well as JSON, see content.go
+// the RESTful routes for reading records are in records.go, and the Server-Sent Events stream
+// of them in sse.go
+// and the metrics of the server and the log in the Prometheus text format on /metrics, and its
+// health on /healthz and /readyz, see health.go
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// CommitLog is the log the server appends records to and reads them from, internal/log.Log
+// implements it. The context is the request's, the log should stop waiting on the request's
+// behalf once it is done. WaitContext blocks until the log has a record at the offset, for the
+// consumers that follow the log.
+type CommitLog interface {
+	AppendContext(context.Context, *api.Record) (uint64, error)
+	ReadContext(context.Context, uint64) (*api.Record, error)
+	WaitContext(context.Context, uint64) error
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+	NextOffset() (uint64, error)
+	Truncate(lowest uint64) error
+}
+
+// Config holds what the server is built from
+type Config struct {
+	CommitLog CommitLog
+	// Registry collects the metrics of the server, and of the commit log when it implements
+	// prometheus.Collector. A new registry is used when it's nil.
+	Registry *prometheus.Registry
+	// RequestTimeout bounds how long a request may wait on the log, zero means no limit. The streams
+	// of /records/stream and /ws aren't bounded by it.
+	RequestTimeout time.Duration
+	// RateLimits limits the produce and consume requests of every client, nil means no limits
+	RateLimits *RateLimits
+	// TLSConfig is what the servers serve TLS with, see internal/config. nil means plain text.
+	TLSConfig *tls.Config
+	// Authorizer decides which clients may produce, consume and use the admin endpoints, nil
+	// allows everyone everything
+	Authorizer Authorizer
+	// Tokens authenticates the bearer tokens of HTTP requests, nil ignores the Authorization header
+	Tokens TokenAuthenticator
+	// OpenAdmin serves the admin endpoints and /metrics to the clients the Authorizer allows, or to
+	// everyone without one, even anonymous ones. They are refused to anonymous clients, and to
+	// everyone without an Authorizer, otherwise.
+	OpenAdmin bool
+}
+
 // Handler Functions ->
 // unmarshalls the request json body into the produce and consume structs
 // runs the logic through the struct methods
@@ -19,36 +71,138 @@ import (
 
 // addr is the address on which the server would run
 // returns the net/http server pointer
-func NewHTTPServer(addr string) *http.Server {
+func NewHTTPServer(addr string, config *Config) (*http.Server, error) {
 
 	// returns an httpServer struct instace
-	https := newHTTPServer()
+	https := newHTTPServer(config)
+
+	reg := config.Registry
+	if reg == nil {
+		reg = prometheus.NewRegistry()
+	}
+	if err := https.metrics.register(reg); err != nil {
+		return nil, err
+	}
+	if c, ok := config.CommitLog.(prometheus.Collector); ok {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
 	r := mux.NewRouter()
+	r.Use(https.identify, https.metrics.middleware, https.authenticate)
 
 	// macthes the route to their handlers
-	r.HandleFunc("/", https.handleProduce).Methods("POST")
-	r.HandleFunc("/", https.handleConsume).Methods("GET")
+	produce, consume := https.authorized(auth.ActionProduce), https.authorized(auth.ActionConsume)
+	// the streams stay open for as long as the client follows the log, they don't get the request
+	// timeout of the other routes, and are held to the rate limits event by event
+	r.Handle("/records/stream", consume(http.HandlerFunc(https.handleStream))).Methods("GET")
+	// the WebSocket channel authorizes each message, a connection may only produce or only consume
+	r.HandleFunc("/ws", https.handleWebSocket).Methods("GET")
+
+	timed := r.NewRoute().Subrouter()
+	if config.RequestTimeout > 0 {
+		timed.Use(timeout(config.RequestTimeout))
+	}
+	timed.Handle("/", produce(https.limit(opProduce, https.handleProduce))).Methods("POST")
+	timed.Handle("/", consume(https.limit(opConsume, https.handleConsume))).Methods("GET")
+	timed.Handle("/records/{offset:[0-9]+}", consume(https.limit(opConsume, https.handleRecord))).Methods("GET")
+	timed.Handle("/records", consume(https.limit(opConsume, https.handleRecords))).Methods("GET")
+	timed.Handle("/offsets", consume(http.HandlerFunc(https.handleOffsets))).Methods("GET")
+	timed.HandleFunc("/healthz", https.handleHealthz).Methods("GET")
+	timed.HandleFunc("/readyz", https.handleReadyz).Methods("GET")
+	timed.Handle("/metrics", https.authorized(auth.ActionAdmin)(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))).Methods("GET")
+
+	admin := timed.PathPrefix("/admin").Subrouter()
+	admin.Use(https.authorized(auth.ActionAdmin))
+	admin.HandleFunc("/quotas", https.limiter.handleQuotas).Methods("GET")
+	admin.HandleFunc("/segments", https.handleSegments).Methods("GET")
+	admin.HandleFunc("/offsets", https.handleOffsets).Methods("GET")
+	admin.HandleFunc("/truncate", https.handleTruncate).Methods("POST")
+	admin.HandleFunc("/roll", https.handleRoll).Methods("POST")
+	admin.HandleFunc("/verify", https.handleVerify).Methods("POST")
 
-	return &http.Server{
-		Addr:    addr,
-		Handler: r,
+	// serve with ListenAndServeTLS("", "") when TLSConfig is set, the certificates are in it
+	https.router = r
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   https,
+		TLSConfig: config.TLSConfig,
 	}
+	// Shutdown waits for the requests in flight, the streams end on their own, see WaitWebSockets
+	// for the WebSocket connections
+	srv.RegisterOnShutdown(https.stopStreams)
+	return srv, nil
+}
+
+func (server *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.router.ServeHTTP(w, r)
 }
 
 type httpServer struct {
-	Log *Log
+	router     http.Handler
+	Log        CommitLog
+	metrics    *httpMetrics
+	limiter    *rateLimiter
+	limited    bool
+	authorizer Authorizer
+	tokens     TokenAuthenticator
+	openAdmin  bool
+	// maxBody bounds the request bodies, see content.go
+	maxBody int64
+	// shutdown is closed when the http.Server starts shutting down, see health.go. The WebSocket
+	// connections are counted in websockets until then, mu guards the two.
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	mu           sync.Mutex
+	websockets   sync.WaitGroup
 }
 
 // similar to a constructor function, returns a pointer to the httpServer struct above
-func newHTTPServer() *httpServer {
+func newHTTPServer(config *Config) *httpServer {
+	limits := config.RateLimits
+	if limits == nil {
+		limits = &RateLimits{}
+	}
 	return &httpServer{
-		Log: NewLog(),
+		Log:        config.CommitLog,
+		metrics:    newHTTPMetrics(),
+		limiter:    newRateLimiter(limits),
+		limited:    config.RateLimits != nil,
+		authorizer: config.Authorizer,
+		tokens:     config.Tokens,
+		openAdmin:  config.OpenAdmin,
+		maxBody:    maxBodyBytes(config.CommitLog),
+		shutdown:   make(chan struct{}),
+	}
+}
+
+// limit applies the rate limits of the operation to the handler when limits are configured
+func (server *httpServer) limit(op string, h http.HandlerFunc) http.HandlerFunc {
+	if !server.limited {
+		return h
 	}
+	return server.limiter.wrap(op, h)
+}
+
+// throttle holds a stream of the operation to the rate limits when limits are configured, see
+// rateLimiter.throttle
+func (server *httpServer) throttle(ctx context.Context, client, op string, n int) error {
+	if !server.limited {
+		return nil
+	}
+	return server.limiter.throttle(ctx, client, op, int64(n))
+}
+
+// Record is a record of the log as the JSON API sends and receives it
+type Record struct {
+	Value  []byte `json:"value"`
+	Offset uint64 `json:"offset"`
 }
 
 // Struct where record is unmarshalled and write to log using the Handler
 type ProduceRequest struct {
-	Record Record `json:record`
+	Record Record `json:"record"`
 }
 
 // Struct where response record read's index from log is unmarshalled and sent
@@ -74,57 +228,105 @@ type ConsumeResponse struct {
 // - uses the struct to append record into the log
 // - marshalls the results ( ProduceResponse struct) into the response
 func (server *httpServer) handleProduce(write http.ResponseWriter, r *http.Request) {
-	var req ProduceRequest
-	// unmarshals the request body into the Produce Request struct
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(write, err.Error(), http.StatusBadRequest)
-		return
+	// unmarshals the request body into the Produce Request struct, or takes the body as the value
+	server.limitBody(write, r)
+	var record *api.Record
+	if contentType(r) == contentOctetStream {
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(write, bodyError(err))
+			return
+		}
+		record = &api.Record{Value: value}
+	} else {
+		var req ProduceRequest
+		pb := &api.ProduceRequest{}
+		if err := readBody(r, &req, pb); err != nil {
+			writeError(write, err)
+			return
+		}
+		record = &api.Record{Value: req.Record.Value}
+		if contentType(r) == contentProtobuf {
+			record.Value = pb.GetRecord().GetValue()
+		}
 	}
-	off, err := server.Log.Append(req.Record)
+	off, err := server.Log.AppendContext(r.Context(), record)
 
 	if err != nil {
-		http.Error(write, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	res := ProduceResponse{Offset: off}
-	err = json.NewEncoder(write).Encode(res)
-	if err != nil {
-		http.Error(write, err.Error(), http.StatusInternalServerError)
+		writeError(write, err)
 		return
 	}
 
+	writeResponse(write, r, ProduceResponse{Offset: off}, &api.ProduceResponse{Offset: off})
 }
 
 // Does the same thing as handleProduce but uses Read to read from the log
 
 func (server *httpServer) handleConsume(write http.ResponseWriter, r *http.Request) {
+	server.limitBody(write, r)
 	var req ConsumeRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(write, err.Error(), http.StatusBadRequest)
+	pb := &api.ConsumeRequest{}
+	if err := readBody(r, &req, pb); err != nil {
+		writeError(write, err)
+		return
+	}
+	if contentType(r) == contentProtobuf {
+		req.Offset = pb.Offset
 	}
 
 	// reads fromt the log
-	record, err := server.Log.Read(req.Offset)
+	record, err := server.Log.ReadContext(r.Context(), req.Offset)
 
-	if err == ErrOffsetNotFound {
-		http.Error(write, err.Error(), http.StatusNotFound)
+	if err != nil {
+		writeError(write, err)
 		return
 	}
 
+	res := ConsumeResponse{Record: Record{Value: record.Value, Offset: record.Offset}}
+	writeRecordResponse(write, r, record, res, &api.ConsumeResponse{Record: record})
+}
+
+// Struct where the lowest and highest offsets of the log are sent
+type OffsetsResponse struct {
+	Lowest  uint64 `json:"lowest"`
+	Highest uint64 `json:"highest"`
+}
+
+// handleOffsets sends the range of offsets in the log
+func (server *httpServer) handleOffsets(write http.ResponseWriter, r *http.Request) {
+	res, err := server.offsets()
 	if err != nil {
-		http.Error(write, err.Error(), http.StatusInternalServerError)
+		writeError(write, err)
 		return
 	}
+	writeJSON(write, res)
+}
 
-	res := ConsumeResponse{Record: record}
-	err = json.NewEncoder(write).Encode(res)
+func (server *httpServer) offsets() (OffsetsResponse, error) {
+	var res OffsetsResponse
+	var err error
+	if res.Lowest, err = server.Log.LowestOffset(); err != nil {
+		return res, err
+	}
+	res.Highest, err = server.Log.HighestOffset()
+	return res, err
+}
 
-	if err != nil {
-		http.Error(write, err.Error(), http.StatusInternalServerError)
-		return
+// writeJSON writes v as the JSON body of the response
+func writeJSON(write http.ResponseWriter, v interface{}) {
+	write.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(write).Encode(v); err != nil {
+		writeError(write, err)
 	}
+}
 
+// timeout gives every request a deadline of d
+func timeout(d time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hamza-yusuff/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTest returns a server backed by a log in a temporary directory, fn can change the config
+// before the server is built
+func setupTest(t *testing.T, fn func(*Config)) (http.Handler, *log.Log, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "server-test")
+	require.NoError(t, err)
+	c := log.Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxRecordBytes = 1024
+	clog, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+
+	config := &Config{CommitLog: clog}
+	if fn != nil {
+		fn(config)
+	}
+	srv, err := NewHTTPServer(":0", config)
+	require.NoError(t, err)
+	return srv.Handler, clog, func() {
+		clog.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func jsonBody(t *testing.T, body interface{}) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&b).Encode(body))
+	}
+	return &b
+}
+
+func do(t *testing.T, h http.Handler, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, jsonBody(t, body)))
+	return w
+}
+
+func TestProduceConsume(t *testing.T) {
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	for i := 0; i < 2; i++ {
+		w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+		require.Equal(t, http.StatusOK, w.Code)
+		var res ProduceResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, uint64(i), res.Offset)
+	}
+
+	w := do(t, h, "GET", "/", ConsumeRequest{Offset: 1})
+	require.Equal(t, http.StatusOK, w.Code)
+	var res ConsumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, []byte("hello world"), res.Record.Value)
+	require.Equal(t, uint64(1), res.Record.Offset)
+}
+
+func TestMetrics(t *testing.T) {
+	h, _, teardown := setupTest(t, func(c *Config) { c.OpenAdmin = true })
+	defer teardown()
+
+	w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = do(t, h, "GET", "/metrics", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	for _, want := range []string{
+		`proglog_http_requests_total{code="200",method="POST",route="/"} 1`,
+		`proglog_log_appended_records_total 1`,
+		`proglog_log_segments 1`,
+		`proglog_log_append_duration_seconds_count 1`,
+	} {
+		require.True(t, strings.Contains(body, want), "missing %q in:\n%s", want, body)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	// a client that went away doesn't get its record appended
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := jsonBody(t, ProduceRequest{Record: Record{Value: []byte("hello world")}})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", body).WithContext(ctx))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = do(t, h, "GET", "/", ConsumeRequest{Offset: 0})
+	require.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestErrorResponses(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+
+	requireError := func(w *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		require.Equal(t, status, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var res ErrorResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, code, res.Code)
+		require.NotEmpty(t, res.Error)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("{")))
+	requireError(w, http.StatusBadRequest, CodeBadRequest)
+
+	requireError(do(t, h, "GET", "/", ConsumeRequest{Offset: 1}), http.StatusNotFound, CodeOffsetOutOfRange)
+
+	big := ProduceRequest{Record: Record{Value: make([]byte, 2048)}}
+	requireError(do(t, h, "POST", "/", big), http.StatusRequestEntityTooLarge, CodeRecordTooLarge)
+
+	require.NoError(t, clog.Close())
+	requireError(do(t, h, "GET", "/", ConsumeRequest{Offset: 0}), http.StatusServiceUnavailable, CodeLogClosed)
+}
+
+func TestOffsetsTruncate(t *testing.T) {
+	h, _, teardown := setupTest(t, func(c *Config) { c.OpenAdmin = true })
+	defer teardown()
+
+	for i := 0; i < 3; i++ {
+		w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var offsets OffsetsResponse
+	w := do(t, h, "GET", "/offsets", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&offsets))
+	require.Equal(t, OffsetsResponse{Lowest: 0, Highest: 2}, offsets)
+
+	// the active segment is never truncated away
+	w = do(t, h, "POST", "/admin/truncate", TruncateRequest{Lowest: 5})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&offsets))
+	require.Equal(t, OffsetsResponse{Lowest: 0, Highest: 2}, offsets)
+
+	w = do(t, h, "POST", "/admin/truncate", "five")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTruncateSegments(t *testing.T) {
+	h, _, teardown := setupTest(t, func(c *Config) { c.OpenAdmin = true })
+	defer teardown()
+
+	// segments of offsets 0 to 2, 3 and 4, and the active one from 5
+	for _, n := range []int{3, 1, 1} {
+		for i := 0; i < n; i++ {
+			w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		require.Equal(t, http.StatusOK, do(t, h, "POST", "/admin/roll", nil).Code)
+	}
+
+	truncate := func(lowest, want uint64) {
+		t.Helper()
+		var offsets OffsetsResponse
+		w := do(t, h, "POST", "/admin/truncate", TruncateRequest{Lowest: lowest})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&offsets))
+		require.Equal(t, want, offsets.Lowest)
+	}
+	// a segment with a record above lowest is kept
+	truncate(1, 0)
+	// the first two segments have no records above 3, the record at 3 goes with its segment
+	truncate(3, 4)
+	require.Equal(t, http.StatusNotFound, do(t, h, "GET", "/records/3", nil).Code)
+	require.Equal(t, http.StatusOK, do(t, h, "GET", "/records/4", nil).Code)
+}
@@ -0,0 +1,98 @@
+package server
+
+// The identity of a client is the subject of the certificate it authenticated with over mutual
+// TLS, its common name, or the subject of its bearer token (see tokens.go). It is put in the
+// request's context for the handlers, and whatever authorizes them, to find. Clients without a
+// certificate or a token have no identity, an empty subject.
+// HTTP requests are identified before anything else, so the metrics see who the request was from,
+// a request whose token can't be authenticated is refused after the metrics, see tokens.go.
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type subjectContextKey struct{}
+
+// Subject returns the identity of the client of the request ctx belongs to
+func Subject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectContextKey{}).(string)
+	return subject
+}
+
+// withSubject returns a copy of ctx identifying the client as subject
+func withSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectContextKey{}, subject)
+}
+
+// tlsSubject returns the common name of the client's certificate, the certificate has been
+// verified during the handshake when the server asks for one
+func tlsSubject(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
+
+// errCertificateAndToken refuses the requests that could be two clients at once
+var errCertificateAndToken = errors.New("request has both a client certificate and a bearer token")
+
+// identify puts the subject of the client's certificate or bearer token in the context of HTTP
+// requests, or the reason the client couldn't be identified
+func (server *httpServer) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := tlsSubject(r.TLS)
+		var err error
+		if header := r.Header.Get("Authorization"); server.tokens != nil && header != "" {
+			if subject != "" {
+				subject, err = "", errCertificateAndToken
+			} else {
+				subject, err = server.tokenSubject(header)
+			}
+		}
+		ctx := withSubject(r.Context(), subject)
+		if err != nil {
+			ctx = context.WithValue(ctx, authErrorContextKey{}, err)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// grpcSubject returns the subject of the certificate of the gRPC peer of ctx
+func grpcSubject(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	return tlsSubject(&info.State)
+}
+
+// identifyUnary and identifyStream are identify for the gRPC server
+func identifyUnary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(withSubject(ctx, grpcSubject(ctx)), req)
+}
+
+func identifyStream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := ss.Context()
+	return handler(srv, &serverStream{ServerStream: ss, ctx: withSubject(ctx, grpcSubject(ctx))})
+}
+
+// serverStream replaces the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
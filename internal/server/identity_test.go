@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentify(t *testing.T) {
+	server := &httpServer{tokens: tokenMap{"secret": "bob"}}
+	var got string
+	var authErr error
+	h := server.identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = Subject(r.Context())
+		authErr, _ = r.Context().Value(authErrorContextKey{}).(error)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "", got)
+
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+		{Subject: pkix.Name{CommonName: "alice"}},
+	}}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "alice", got)
+	require.NoError(t, authErr)
+
+	// a certificate and a token together aren't anyone
+	r.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "", got)
+	require.Equal(t, errCertificateAndToken, authErr)
+
+	r.TLS = nil
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "bob", got)
+	require.NoError(t, authErr)
+}
+
+func TestWithSubject(t *testing.T) {
+	// the context handed out before is left alone
+	ctx := withSubject(context.Background(), "alice")
+	bob := withSubject(ctx, "bob")
+	require.Equal(t, "alice", Subject(ctx))
+	require.Equal(t, "bob", Subject(bob))
+}
@@ -0,0 +1,121 @@
+package server
+
+// Metrics of the HTTP server, exposed in the Prometheus text format on /metrics together with the
+// metrics of the commit log when it is a prometheus.Collector, which internal/log.Log is.
+// The metrics name the clients, so reading them is an admin action.
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type httpMetrics struct {
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	clientRequests *prometheus.CounterVec
+	denied         *prometheus.CounterVec
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proglog",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests handled, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "proglog",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		clientRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proglog",
+			Subsystem: "http",
+			Name:      "client_requests_total",
+			Help:      "HTTP requests handled, by the identity of the client and status code.",
+		}, []string{"client", "code"}),
+		denied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proglog",
+			Subsystem: "http",
+			Name:      "denied_total",
+			Help:      "Requests and WebSocket messages denied by the authorization policy, by action.",
+		}, []string{"action"}),
+	}
+}
+
+func (m *httpMetrics) register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{m.requests, m.duration, m.clientRequests, m.denied} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// middleware records every request the router matched, labelled with the route's path template
+// so the label values stay bounded, and with the identity of the client, see identity.go
+func (m *httpMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tmpl, err := cr.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		client := Subject(r.Context())
+		if client == "" {
+			client = "anonymous"
+		}
+		m.clientRequests.WithLabelValues(client, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// statusRecorder remembers the status code written to the response, it passes flushes and
+// hijacks through to the response writer it wraps
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("server: response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
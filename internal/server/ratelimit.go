@@ -0,0 +1,298 @@
+package server
+
+// Per client rate limits for the produce and consume endpoints, so one noisy client can't use up
+// the single log behind the server.
+// Every client gets a token bucket per limit. A request takes one token from the requests bucket
+// before it is handled, and is refused with 429 and a Retry-After header when there isn't one.
+// The size of a request is only known once its body has been read, or its response written, so
+// the bytes buckets are charged after the request and may go into debt. A client in debt is
+// refused until the bucket has refilled.
+// The streams, see sse.go and websocket.go, take a request token and are charged the bytes of
+// every record they send, a client over its limits waits for its buckets to refill instead of
+// losing the stream.
+// Clients are told apart by their identity, see identity.go, and by their remote address when
+// they have none. Nothing a client can choose freely, like a header, picks its buckets, or it
+// could get a fresh burst with every request.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit is a rate in units per second, with a burst of up to Burst units. A zero Rate means no
+// limit, a zero Burst means a burst of one second's worth of Rate.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// RateLimits configures the per client limits of the produce and consume endpoints
+type RateLimits struct {
+	ProduceRequests Limit
+	ProduceBytes    Limit
+	ConsumeRequests Limit
+	ConsumeBytes    Limit
+}
+
+const (
+	opProduce = "produce"
+	opConsume = "consume"
+
+	// idleClient is how long a client can stay away before its state is forgotten, its buckets
+	// have long refilled by then
+	idleClient = 10 * time.Minute
+)
+
+// bucket is a token bucket, tokens can go negative when bytes are charged after the fact
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(l Limit, now time.Time) *bucket {
+	return &bucket{limit: l, tokens: l.burst(), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.burst(), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// wait returns how long until the bucket has n tokens
+func (b *bucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+type clientState struct {
+	buckets map[string]*bucket
+	seen    time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]Limit
+	clients map[string]*clientState
+	now     func() time.Time
+	calls   int
+}
+
+func newRateLimiter(l *RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits: map[string]Limit{
+			opProduce + "_requests": l.ProduceRequests,
+			opProduce + "_bytes":    l.ProduceBytes,
+			opConsume + "_requests": l.ConsumeRequests,
+			opConsume + "_bytes":    l.ConsumeBytes,
+		},
+		clients: make(map[string]*clientState),
+		now:     time.Now,
+	}
+}
+
+// bucket returns the client's bucket for the limit, nil when the limit isn't set. rl.mu must be held.
+func (rl *rateLimiter) bucket(client, name string, now time.Time) *bucket {
+	limit := rl.limits[name]
+	if limit.Rate <= 0 {
+		return nil
+	}
+	c, ok := rl.clients[client]
+	if !ok {
+		c = &clientState{buckets: make(map[string]*bucket)}
+		rl.clients[client] = c
+	}
+	c.seen = now
+	b, ok := c.buckets[name]
+	if !ok {
+		b = newBucket(limit, now)
+		c.buckets[name] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// allow takes a request token for the operation, and checks the client isn't in debt for bytes.
+// It returns how long the client should wait when the request isn't allowed.
+func (rl *rateLimiter) allow(client, op string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	rl.prune(now)
+
+	var wait time.Duration
+	if b := rl.bucket(client, op+"_bytes", now); b != nil {
+		wait = b.wait(0)
+	}
+	if b := rl.bucket(client, op+"_requests", now); b != nil {
+		if w := b.wait(1); w > wait {
+			wait = w
+		}
+		if wait == 0 {
+			b.tokens--
+		}
+	}
+	return wait
+}
+
+// charge takes n byte tokens for the operation
+func (rl *rateLimiter) charge(client, op string, n int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if b := rl.bucket(client, op+"_bytes", rl.now()); b != nil {
+		b.tokens -= float64(n)
+	}
+}
+
+// prune forgets the clients that have been idle, every so often. rl.mu must be held.
+func (rl *rateLimiter) prune(now time.Time) {
+	if rl.calls++; rl.calls%1024 != 0 {
+		return
+	}
+	for key, c := range rl.clients {
+		if now.Sub(c.seen) > idleClient {
+			delete(rl.clients, key)
+		}
+	}
+}
+
+// throttle takes a request token for the operation and charges n bytes, waiting for the client's
+// buckets to refill while it is over its limits, until ctx is done
+func (rl *rateLimiter) throttle(ctx context.Context, client, op string, n int64) error {
+	for {
+		wait := rl.allow(client, op)
+		if wait == 0 {
+			rl.charge(client, op, n)
+			return nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+var errRateLimited = &statusError{
+	status: http.StatusTooManyRequests,
+	code:   CodeRateLimited,
+	err:    errors.New("rate limit exceeded"),
+}
+
+// wrap limits the handler of the operation
+func (rl *rateLimiter) wrap(op string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientKey(r)
+		if wait := rl.allow(client, op); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, errRateLimited)
+			return
+		}
+		if op == opProduce {
+			body := &countingReader{ReadCloser: r.Body}
+			r.Body = body
+			defer func() { rl.charge(client, op, body.n) }()
+			next(w, r)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() { rl.charge(client, op, cw.n) }()
+		next(cw, r)
+	}
+}
+
+// clientKey tells clients apart by their identity, or their remote address
+func clientKey(r *http.Request) string {
+	if subject := Subject(r.Context()); subject != "" {
+		return "subject:" + subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// QuotaState is the state of a client's bucket, as reported by the admin endpoint
+type QuotaState struct {
+	Limit
+	Tokens float64 `json:"tokens"`
+}
+
+// ClientQuota is the state of all the buckets of a client
+type ClientQuota struct {
+	Client  string                `json:"client"`
+	Buckets map[string]QuotaState `json:"buckets"`
+}
+
+// handleQuotas reports the quota state of every client the limiter knows about
+func (rl *rateLimiter) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	rl.mu.Lock()
+	now := rl.now()
+	quotas := make([]ClientQuota, 0, len(rl.clients))
+	for key, c := range rl.clients {
+		q := ClientQuota{Client: key, Buckets: make(map[string]QuotaState)}
+		for name, b := range c.buckets {
+			b.refill(now)
+			q.Buckets[name] = QuotaState{Limit: b.limit, Tokens: b.tokens}
+		}
+		quotas = append(quotas, q)
+	}
+	rl.mu.Unlock()
+
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].Client < quotas[j].Client
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(quotas); err != nil {
+		writeError(w, err)
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(&RateLimits{
+		ProduceRequests: Limit{Rate: 1, Burst: 2},
+		ConsumeBytes:    Limit{Rate: 100},
+	})
+	now := time.Unix(0, 0)
+	rl.now = func() time.Time { return now }
+
+	// the burst is allowed, then the client has to wait for a token
+	require.Equal(t, time.Duration(0), rl.allow("a", opProduce))
+	require.Equal(t, time.Duration(0), rl.allow("a", opProduce))
+	require.Equal(t, time.Second, rl.allow("a", opProduce))
+	// other clients have buckets of their own
+	require.Equal(t, time.Duration(0), rl.allow("b", opProduce))
+	now = now.Add(time.Second)
+	require.Equal(t, time.Duration(0), rl.allow("a", opProduce))
+
+	// bytes are charged after the request, a client in debt waits for the debt to refill
+	require.Equal(t, time.Duration(0), rl.allow("a", opConsume))
+	rl.charge("a", opConsume, 300)
+	require.Equal(t, 2*time.Second, rl.allow("a", opConsume))
+	now = now.Add(2 * time.Second)
+	require.Equal(t, time.Duration(0), rl.allow("a", opConsume))
+}
+
+func TestRateLimitedServer(t *testing.T) {
+	h, _, teardown := setupTest(t, func(c *Config) {
+		c.RateLimits = &RateLimits{ProduceRequests: Limit{Rate: 1}}
+		c.OpenAdmin = true
+	})
+	defer teardown()
+
+	produce := func(host, apiKey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", jsonBody(t, ProduceRequest{Record: Record{Value: []byte("hello world")}}))
+		r.RemoteAddr = host + ":1234"
+		if apiKey != "" {
+			r.Header.Set("X-Api-Key", apiKey)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+	require.Equal(t, http.StatusOK, produce("10.0.0.1", "").Code)
+	w := produce("10.0.0.1", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+	// a header of the client's choosing doesn't get it a bucket of its own
+	require.Equal(t, http.StatusTooManyRequests, produce("10.0.0.1", "fresh").Code)
+	require.Equal(t, http.StatusOK, produce("10.0.0.2", "").Code)
+
+	w = do(t, h, "GET", "/admin/quotas", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var quotas []ClientQuota
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&quotas))
+	require.Equal(t, 2, len(quotas))
+	require.Equal(t, "addr:10.0.0.1", quotas[0].Client)
+	require.Equal(t, float64(1), quotas[0].Buckets["produce_requests"].Rate)
+}
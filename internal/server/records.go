@@ -0,0 +1,116 @@
+package server
+
+// RESTful routes for reading the log, the offsets go in the URL instead of a JSON body on a GET,
+// which proxies and browsers drop:
+//
+//	GET /records/{offset}                   the record at the offset
+//	GET /records?from=&max=&maxBytes=       a page of records from the offset on
+//
+// A page ends at the end of the log, after max records, or before the record that would take the
+// values of the page past maxBytes, it always has at least one record when the log has one at
+// from. Next is the offset to ask for the following page with.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	plog "github.com/hamza-yusuff/proglog/internal/log"
+)
+
+const (
+	// defaultPageRecords and maxPageRecords bound the records of a page
+	defaultPageRecords = 100
+	maxPageRecords     = 1000
+	// defaultPageBytes is the maxBytes of a page when the request doesn't set it
+	defaultPageBytes = 1 << 20
+)
+
+// RecordsResponse is a page of records
+type RecordsResponse struct {
+	Records []Record `json:"records"`
+	Next    uint64   `json:"next"`
+}
+
+// handleRecord sends the record at the offset of the URL
+func (server *httpServer) handleRecord(write http.ResponseWriter, r *http.Request) {
+	off, err := strconv.ParseUint(mux.Vars(r)["offset"], 10, 64)
+	if err != nil {
+		writeError(write, badRequest(err))
+		return
+	}
+	record, err := server.Log.ReadContext(r.Context(), off)
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	writeRecordResponse(write, r, record, Record{Value: record.Value, Offset: record.Offset}, record)
+}
+
+// handleRecords sends a page of records
+func (server *httpServer) handleRecords(write http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := queryUint(q.Get("from"), 0)
+	if err != nil {
+		writeError(write, badRequest(fmt.Errorf("from: %v", err)))
+		return
+	}
+	max, err := queryUint(q.Get("max"), defaultPageRecords)
+	if err != nil || max == 0 {
+		writeError(write, badRequest(fmt.Errorf("max must be a positive number")))
+		return
+	}
+	if max > maxPageRecords {
+		max = maxPageRecords
+	}
+	maxBytes, err := queryUint(q.Get("maxBytes"), defaultPageBytes)
+	if err != nil || maxBytes == 0 {
+		writeError(write, badRequest(fmt.Errorf("maxBytes must be a positive number")))
+		return
+	}
+
+	// an offset below the log has been truncated away, one above it just has no records yet
+	lowest, err := server.Log.LowestOffset()
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+	if from < lowest {
+		writeError(write, &plog.OffsetOutOfRangeError{Offset: from})
+		return
+	}
+
+	res := RecordsResponse{Records: []Record{}, Next: from}
+	pb := &api.RecordsResponse{}
+	var size uint64
+	for uint64(len(res.Records)) < max {
+		record, err := server.Log.ReadContext(r.Context(), res.Next)
+		if errors.Is(err, plog.ErrOffsetOutOfRange) {
+			break
+		}
+		if err != nil {
+			writeError(write, err)
+			return
+		}
+		size += uint64(len(record.Value))
+		if len(res.Records) > 0 && size > maxBytes {
+			break
+		}
+		res.Records = append(res.Records, Record{Value: record.Value, Offset: record.Offset})
+		pb.Records = append(pb.Records, record)
+		res.Next++
+	}
+	pb.Next = res.Next
+	writeResponse(write, r, res, pb)
+}
+
+// queryUint parses a number of the query, def is the value of a missing one
+func queryUint(s string, def uint64) (uint64, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecords(t *testing.T) {
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	for i := 0; i < 5; i++ {
+		value := []byte(fmt.Sprintf("record %d", i))
+		w := do(t, h, "POST", "/", ProduceRequest{Record: Record{Value: value}})
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var record Record
+	w := do(t, h, "GET", "/records/3", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&record))
+	require.Equal(t, Record{Value: []byte("record 3"), Offset: 3}, record)
+	require.Equal(t, http.StatusNotFound, do(t, h, "GET", "/records/5", nil).Code)
+
+	page := func(query string) RecordsResponse {
+		t.Helper()
+		w := do(t, h, "GET", "/records"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res RecordsResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return res
+	}
+	res := page("?from=1&max=2")
+	require.Len(t, res.Records, 2)
+	require.Equal(t, uint64(1), res.Records[0].Offset)
+	require.Equal(t, uint64(3), res.Next)
+
+	res = page("?from=3")
+	require.Len(t, res.Records, 2)
+	require.Equal(t, uint64(5), res.Next)
+
+	// a page always has a record, even one bigger than maxBytes
+	res = page("?from=0&maxBytes=10")
+	require.Len(t, res.Records, 1)
+	require.Equal(t, uint64(1), res.Next)
+
+	// past the end of the log the page is empty
+	res = page("?from=5")
+	require.Empty(t, res.Records)
+	require.Equal(t, uint64(5), res.Next)
+
+	for _, query := range []string{"?from=x", "?max=0", "?maxBytes=-1"} {
+		require.Equal(t, http.StatusBadRequest, do(t, h, "GET", "/records"+query, nil).Code)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+// Following the log over HTTP with Server-Sent Events:
+//
+//	GET /records/stream?from=<offset|latest>
+//
+// Every record is sent as a "record" event with its offset as the event's ID and the record as
+// JSON data. The stream starts at from, at the end of the log when it's latest or missing, or
+// right after the ID of the Last-Event-ID header, which browsers send when they reconnect. Once it
+// has caught up, the stream waits for new records until the client goes away, sending a comment
+// every keepAliveInterval so proxies don't close the connection as idle.
+// Every event is a consume request of the rate limits, see ratelimit.go, a client over its limits
+// gets its next event once its buckets have refilled.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	plog "github.com/hamza-yusuff/proglog/internal/log"
+)
+
+var keepAliveInterval = 15 * time.Second
+
+// handleStream streams the records of the log as Server-Sent Events
+func (server *httpServer) handleStream(write http.ResponseWriter, r *http.Request) {
+	flusher, ok := write.(http.Flusher)
+	if !ok {
+		writeError(write, errors.New("streaming is not supported by the connection"))
+		return
+	}
+	ctx, cancel := server.streamContext(r.Context())
+	defer cancel()
+	client := clientKey(r)
+	off, err := server.streamStart(r)
+	if err != nil {
+		writeError(write, err)
+		return
+	}
+
+	h := write.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// nginx buffers responses unless told otherwise
+	h.Set("X-Accel-Buffering", "no")
+	write.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for ; ; off++ {
+		if err := server.waitKeepAlive(ctx, write, flusher, off); err != nil {
+			// the client went away, the server is shutting down or the log was closed, there is
+			// no one to tell
+			return
+		}
+		record, err := server.Log.ReadContext(ctx, off)
+		if err != nil {
+			// the record was truncated away before it could be sent
+			_, code := errorStatus(err)
+			writeEvent(write, "error", "", ErrorResponse{Error: err.Error(), Code: code})
+			flusher.Flush()
+			return
+		}
+		if err = server.throttle(ctx, client, opConsume, len(record.Value)); err != nil {
+			return
+		}
+		if err = writeEvent(write, "record", strconv.FormatUint(off, 10), Record{Value: record.Value, Offset: record.Offset}); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// streamStart returns the offset the stream starts at
+func (server *httpServer) streamStart(r *http.Request) (uint64, error) {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return 0, badRequest(fmt.Errorf("Last-Event-ID: %v", err))
+		}
+		return server.checkStart(last + 1)
+	}
+	from := r.URL.Query().Get("from")
+	if from == "" || from == "latest" {
+		return server.Log.NextOffset()
+	}
+	off, err := strconv.ParseUint(from, 10, 64)
+	if err != nil {
+		return 0, badRequest(fmt.Errorf("from: %v", err))
+	}
+	return server.checkStart(off)
+}
+
+// checkStart refuses to start below the log, the records there have been truncated away
+func (server *httpServer) checkStart(off uint64) (uint64, error) {
+	lowest, err := server.Log.LowestOffset()
+	if err != nil {
+		return 0, err
+	}
+	if off < lowest {
+		return 0, &plog.OffsetOutOfRangeError{Offset: off}
+	}
+	return off, nil
+}
+
+// waitKeepAlive waits for the log to have a record at off, keeping the connection alive meanwhile
+func (server *httpServer) waitKeepAlive(ctx context.Context, write http.ResponseWriter, flusher http.Flusher, off uint64) error {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, keepAliveInterval)
+		err := server.Log.WaitContext(waitCtx, off)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			return err
+		}
+		if _, err = fmt.Fprint(write, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event with v as its JSON data
+func writeEvent(write http.ResponseWriter, event, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err = fmt.Fprintf(write, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(write, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+type event struct {
+	id, name, data string
+}
+
+// readEvents returns the events of the stream, skipping comments
+func readEvents(t *testing.T, s *bufio.Scanner, n int) []event {
+	t.Helper()
+	var events []event
+	var e event
+	for len(events) < n && s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			if e.name != "" {
+				events = append(events, e)
+			}
+			e = event{}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	require.NoError(t, s.Err())
+	return events
+}
+
+func TestStream(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, v := range []string{"first", "second"} {
+		_, err := clog.Append(&api.Record{Value: []byte(v)})
+		require.NoError(t, err)
+	}
+
+	stream := func(query, lastEventID string) (*http.Response, *bufio.Scanner) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/records/stream"+query, nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return res, bufio.NewScanner(res.Body)
+	}
+
+	res, s := stream("?from=0", "")
+	defer res.Body.Close()
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := readEvents(t, s, 2)
+	require.Equal(t, "0", events[0].id)
+	require.Equal(t, "record", events[0].name)
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(events[1].data), &record))
+	require.Equal(t, Record{Value: []byte("second"), Offset: 1}, record)
+
+	// latest only gets the records appended from now on
+	latest, ls := stream("", "")
+	defer latest.Body.Close()
+	// resuming starts after the last event seen
+	resumed, rs := stream("?from=0", "0")
+	defer resumed.Body.Close()
+	require.Equal(t, "1", readEvents(t, rs, 1)[0].id)
+
+	_, err := clog.Append(&api.Record{Value: []byte("third")})
+	require.NoError(t, err)
+	require.Equal(t, "2", readEvents(t, s, 1)[0].id)
+	require.Equal(t, "2", readEvents(t, ls, 1)[0].id)
+	require.Equal(t, "2", readEvents(t, rs, 1)[0].id)
+
+	res, _ = stream("?from=x", "")
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestStreamKeepAlive(t *testing.T) {
+	defer func(d time.Duration) { keepAliveInterval = d }(keepAliveInterval)
+	keepAliveInterval = 10 * time.Millisecond
+
+	h, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/records/stream")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	s := bufio.NewScanner(res.Body)
+	require.True(t, s.Scan())
+	require.Equal(t, ": keep-alive", s.Text())
+}
+
+func TestStreamOutlivesRequestTimeout(t *testing.T) {
+	h, clog, teardown := setupTest(t, func(c *Config) { c.RequestTimeout = 20 * time.Millisecond })
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/records/stream?from=0")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// the stream is still open well past the request timeout
+	time.Sleep(100 * time.Millisecond)
+	_, err = clog.Append(&api.Record{Value: []byte("late")})
+	require.NoError(t, err)
+	events := readEvents(t, bufio.NewScanner(res.Body), 1)
+	require.Len(t, events, 1)
+	require.Equal(t, "0", events[0].id)
+}
+
+func TestStreamLatestEmptyLog(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// the log is left with an empty active segment at offset 2
+	for _, v := range []string{"first", "second"} {
+		_, err := clog.Append(&api.Record{Value: []byte(v)})
+		require.NoError(t, err)
+	}
+	_, err := clog.Roll()
+	require.NoError(t, err)
+	require.NoError(t, clog.Truncate(1))
+
+	res, err := http.Get(ts.URL + "/records/stream?from=latest")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	_, err = clog.Append(&api.Record{Value: []byte("third")})
+	require.NoError(t, err)
+	events := readEvents(t, bufio.NewScanner(res.Body), 1)
+	require.Len(t, events, 1)
+	require.Equal(t, "record", events[0].name)
+	require.Equal(t, "2", events[0].id)
+}
+
+func TestStreamRateLimits(t *testing.T) {
+	limits := &RateLimits{ConsumeRequests: Limit{Rate: 20, Burst: 1}}
+	h, clog, teardown := setupTest(t, func(c *Config) { c.RateLimits = limits })
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// every event takes a request, the stream waits for the bucket instead of ending
+	start := time.Now()
+	res, err := http.Get(ts.URL + "/records/stream?from=0")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	events := readEvents(t, bufio.NewScanner(res.Body), 5)
+	require.Len(t, events, 5)
+	for i, e := range events {
+		require.Equal(t, "record", e.name)
+		require.Equal(t, strconv.Itoa(i), e.id)
+	}
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
@@ -0,0 +1,42 @@
+package server
+
+// Bearer token authentication of the HTTP API. A request with an Authorization: Bearer header is
+// identified by the subject of its token, one with a token the TokenAuthenticator doesn't know is
+// refused with 401. Requests without the header go through unidentified, or identified by their
+// client certificate, and are left to the Authorizer. A request with both a client certificate
+// and a token is refused with 401 as well, rather than one of them silently winning.
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TokenAuthenticator returns the subject of a bearer token, internal/auth.TokenStore implements it
+type TokenAuthenticator interface {
+	Authenticate(token string) (string, error)
+}
+
+const bearerPrefix = "Bearer "
+
+type authErrorContextKey struct{}
+
+// tokenSubject returns the subject of the bearer token of the Authorization header
+func (server *httpServer) tokenSubject(header string) (string, error) {
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", errors.New("authorization is not a bearer token")
+	}
+	return server.tokens.Authenticate(strings.TrimSpace(header[len(bearerPrefix):]))
+}
+
+// authenticate refuses the requests identify couldn't identify
+func (server *httpServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err, ok := r.Context().Value(authErrorContextKey{}).(error); ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="proglog", error="invalid_token"`)
+			writeError(w, &statusError{status: http.StatusUnauthorized, code: CodeUnauthenticated, err: err})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hamza-yusuff/proglog/internal/auth"
+	"github.com/stretchr/testify/require"
+)
+
+type tokenMap map[string]string
+
+func (m tokenMap) Authenticate(token string) (string, error) {
+	if subject, ok := m[token]; ok {
+		return subject, nil
+	}
+	return "", auth.ErrUnauthenticated
+}
+
+func TestBearerTokens(t *testing.T) {
+	authorizer, err := auth.New(auth.Policy{Rules: []auth.Rule{
+		{Subject: "alice", Actions: []string{auth.Wildcard}},
+	}})
+	require.NoError(t, err)
+	h, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = authorizer
+		c.Tokens = tokenMap{"secret": "alice"}
+	})
+	defer teardown()
+
+	with := func(authorization string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := jsonBody(t, ProduceRequest{Record: Record{Value: []byte("hello world")}})
+		r := httptest.NewRequest("POST", "/", body)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, with("Bearer secret").Code)
+	require.Equal(t, http.StatusForbidden, with("").Code)
+	for _, authorization := range []string{"Bearer wrong", "Basic YWxpY2U6c2VjcmV0"} {
+		w := with(authorization)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		require.Contains(t, w.Body.String(), CodeUnauthenticated)
+	}
+
+	w := with("")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// alice's token doesn't make the client of a certificate alice
+	r := httptest.NewRequest("POST", "/", jsonBody(t, ProduceRequest{Record: Record{Value: []byte("hello world")}}))
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "mallory"}}}}
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), CodeUnauthenticated)
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(w, r)
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `proglog_http_client_requests_total{client="alice",code="200"} 1`)
+	require.Contains(t, string(body), `proglog_http_client_requests_total{client="anonymous",code="401"} 3`)
+	require.Contains(t, string(body), `proglog_http_denied_total{action="produce"} 2`)
+}
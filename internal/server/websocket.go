@@ -0,0 +1,334 @@
+package server
+
+// A WebSocket channel for producing and consuming over a single connection, on GET /ws. Every
+// message is a JSON text frame with a type:
+//
+//	-> {"type": "produce", "id": "1", "value": "aGVsbG8="}
+//	<- {"type": "ack", "id": "1", "offset": 5}
+//	-> {"type": "subscribe", "offset": 0, "credit": 10}
+//	<- {"type": "record", "offset": 0, "value": "aGVsbG8="}
+//	-> {"type": "credit", "credit": 10}
+//	-> {"type": "unsubscribe"}
+//
+// Produce messages are acknowledged in order with the offset of the record, the id is the
+// client's and is sent back as is. A subscription sends the records from the offset on, or from
+// the end of the log with "latest": true, and waits for new ones once it has caught up. Every
+// record takes one credit, the subscription stops sending when it runs out until the client sends
+// more, so a slow client isn't flooded. A failed message gets an error message with the id of the
+// produce message, or none for subscriptions, a failed subscription ends. A message that isn't
+// valid JSON, or doesn't fit WSMessage, gets an error with the bad_request code.
+// The connection's client is held to the rate limits of the HTTP API: every produce message is a
+// produce request, and every record sent a consume request. A produce message over the limits
+// gets an error with the rate_limited code, a subscription over them waits for the client's
+// buckets to refill.
+// Messages may be as big as the request bodies of the HTTP API, see maxBodyBytes.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/hamza-yusuff/proglog/internal/auth"
+)
+
+// Types of the WebSocket messages
+const (
+	wsProduce     = "produce"
+	wsAck         = "ack"
+	wsSubscribe   = "subscribe"
+	wsRecord      = "record"
+	wsCredit      = "credit"
+	wsUnsubscribe = "unsubscribe"
+	wsError       = "error"
+)
+
+const wsWriteTimeout = 10 * time.Second
+
+var (
+	// the connection is closed when the client doesn't answer a ping within wsPongTimeout
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+)
+
+// WSMessage is a message of the WebSocket channel
+type WSMessage struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Value  []byte `json:"value,omitempty"`
+	Offset uint64 `json:"offset"`
+	Latest bool   `json:"latest,omitempty"`
+	Credit uint64 `json:"credit,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{}
+
+// wsConn is a WebSocket connection, gorilla/websocket allows one writer at a time
+type wsConn struct {
+	server *httpServer
+	conn   *websocket.Conn
+	ctx    context.Context
+	mu     sync.Mutex
+	// client is the key of the connection's client in the rate limiter
+	client string
+	// appendCtx is the request's context, appends in flight aren't given up on when the server
+	// shuts down, WaitWebSockets waits for them
+	appendCtx context.Context
+
+	sub *wsSubscription
+}
+
+// wsSubscription sends records to the client while it has credit
+type wsSubscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	credit int64
+	// more is signalled when credit is added
+	more chan struct{}
+}
+
+// errShuttingDown refuses the WebSocket connections opened once the server is shutting down
+var errShuttingDown = &statusError{
+	status: http.StatusServiceUnavailable,
+	code:   CodeCanceled,
+	err:    errors.New("server shutting down"),
+}
+
+// WaitWebSockets waits for the WebSocket connections of srv to end, or for ctx to be done.
+// http.Server.Shutdown doesn't wait for them, the connections are hijacked, so their messages may
+// still be using the log after it has returned. srv must be one of NewHTTPServer's.
+func WaitWebSockets(ctx context.Context, srv *http.Server) error {
+	server, ok := srv.Handler.(*httpServer)
+	if !ok {
+		return errors.New("server: not a server of NewHTTPServer")
+	}
+	server.stopStreams()
+	done := make(chan struct{})
+	go func() {
+		server.websockets.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleWebSocket upgrades the connection and serves its messages until the client goes away
+func (server *httpServer) handleWebSocket(write http.ResponseWriter, r *http.Request) {
+	// counted before the upgrade, Shutdown waits for the request until then
+	server.mu.Lock()
+	select {
+	case <-server.shutdown:
+		server.mu.Unlock()
+		writeError(write, errShuttingDown)
+		return
+	default:
+	}
+	server.websockets.Add(1)
+	server.mu.Unlock()
+	defer server.websockets.Done()
+
+	conn, err := upgrader.Upgrade(write, r, nil)
+	if err != nil {
+		// the upgrader has sent the client an error response
+		return
+	}
+	ctx, cancel := server.streamContext(r.Context())
+	c := &wsConn{server: server, conn: conn, ctx: ctx, client: clientKey(r), appendCtx: r.Context()}
+	defer func() {
+		cancel()
+		c.unsubscribe()
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(server.maxBody)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go c.ping()
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg WSMessage
+		if err = json.Unmarshal(b, &msg); err != nil {
+			c.sendError("", badRequest(err))
+			continue
+		}
+		c.handle(msg)
+	}
+}
+
+func (c *wsConn) handle(msg WSMessage) {
+	switch msg.Type {
+	case wsProduce:
+		if err := c.server.authorizeAction(c.ctx, auth.ActionProduce); err != nil {
+			c.sendError(msg.ID, err)
+			return
+		}
+		if err := c.allow(opProduce, len(msg.Value)); err != nil {
+			c.sendError(msg.ID, err)
+			return
+		}
+		off, err := c.server.Log.AppendContext(c.appendCtx, &api.Record{Value: msg.Value})
+		if err != nil {
+			c.sendError(msg.ID, err)
+			return
+		}
+		c.send(WSMessage{Type: wsAck, ID: msg.ID, Offset: off})
+	case wsSubscribe:
+		if err := c.server.authorizeAction(c.ctx, auth.ActionConsume); err != nil {
+			c.sendError("", err)
+			return
+		}
+		off := msg.Offset
+		if msg.Latest {
+			var err error
+			if off, err = c.server.Log.NextOffset(); err != nil {
+				c.sendError("", err)
+				return
+			}
+		} else if _, err := c.server.checkStart(off); err != nil {
+			c.sendError("", err)
+			return
+		}
+		c.subscribe(off, msg.Credit)
+	case wsCredit:
+		if c.sub != nil {
+			atomic.AddInt64(&c.sub.credit, int64(msg.Credit))
+			select {
+			case c.sub.more <- struct{}{}:
+			default:
+			}
+		}
+	case wsUnsubscribe:
+		c.unsubscribe()
+	default:
+		c.sendError(msg.ID, badRequest(fmt.Errorf("unknown message type %q", msg.Type)))
+	}
+}
+
+// subscribe replaces the subscription of the connection with one from off
+func (c *wsConn) subscribe(off, credit uint64) {
+	c.unsubscribe()
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub := &wsSubscription{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		credit: int64(credit),
+		more:   make(chan struct{}, 1),
+	}
+	c.sub = sub
+	go func() {
+		defer close(sub.done)
+		c.follow(ctx, sub, off)
+	}()
+}
+
+func (c *wsConn) unsubscribe() {
+	if c.sub != nil {
+		c.sub.cancel()
+		<-c.sub.done
+		c.sub = nil
+	}
+}
+
+// follow sends the records from off on while the subscription has credit
+func (c *wsConn) follow(ctx context.Context, sub *wsSubscription, off uint64) {
+	for ; ; off++ {
+		for atomic.LoadInt64(&sub.credit) <= 0 {
+			select {
+			case <-sub.more:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := c.server.Log.WaitContext(ctx, off); err != nil {
+			if ctx.Err() == nil {
+				c.sendError("", err)
+			}
+			return
+		}
+		record, err := c.server.Log.ReadContext(ctx, off)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.sendError("", err)
+			}
+			return
+		}
+		if err = c.server.throttle(ctx, c.client, opConsume, len(record.Value)); err != nil {
+			return
+		}
+		if err = c.send(WSMessage{Type: wsRecord, Offset: record.Offset, Value: record.Value}); err != nil {
+			return
+		}
+		atomic.AddInt64(&sub.credit, -1)
+	}
+}
+
+// ping keeps the connection alive, and finds out when the client is gone. It closes the
+// connection when its context is done, which ends the read loop.
+func (c *wsConn) ping() {
+	t := time.NewTicker(wsPingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.mu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			c.mu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-c.ctx.Done():
+			// the server is shutting down, or the connection is gone already
+			c.mu.Lock()
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(wsWriteTimeout))
+			c.mu.Unlock()
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// allow takes a request of the operation and n bytes from the client's buckets, when the server
+// has rate limits, without waiting for them
+func (c *wsConn) allow(op string, n int) error {
+	if !c.server.limited {
+		return nil
+	}
+	if wait := c.server.limiter.allow(c.client, op); wait > 0 {
+		return errRateLimited
+	}
+	c.server.limiter.charge(c.client, op, int64(n))
+	return nil
+}
+
+func (c *wsConn) send(msg WSMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(msg)
+}
+
+// sendError sends the error, with the status code's error code of the HTTP API
+func (c *wsConn) sendError(id string, err error) {
+	_, code := errorStatus(err)
+	c.send(WSMessage{Type: wsError, ID: id, Error: err.Error(), Code: code})
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	api "github.com/hamza-yusuff/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocket(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	send := func(msg WSMessage) {
+		t.Helper()
+		require.NoError(t, conn.WriteJSON(msg))
+	}
+	recv := func() WSMessage {
+		t.Helper()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var msg WSMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	for i, v := range []string{"first", "second", "third"} {
+		send(WSMessage{Type: wsProduce, ID: v, Value: []byte(v)})
+		require.Equal(t, WSMessage{Type: wsAck, ID: v, Offset: uint64(i)}, recv())
+	}
+
+	// two credits get two records, the third waits for more credit
+	send(WSMessage{Type: wsSubscribe, Offset: 0, Credit: 2})
+	for i, v := range []string{"first", "second"} {
+		require.Equal(t, WSMessage{Type: wsRecord, Offset: uint64(i), Value: []byte(v)}, recv())
+	}
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	var msg WSMessage
+	require.Error(t, conn.ReadJSON(&msg))
+
+	// a timed out read breaks the connection, a new one resumes from the third record
+	conn.Close()
+	conn, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	send(WSMessage{Type: wsSubscribe, Offset: 2})
+	send(WSMessage{Type: wsCredit, Credit: 2})
+	require.Equal(t, WSMessage{Type: wsRecord, Offset: 2, Value: []byte("third")}, recv())
+
+	// caught up, the subscription waits for the next record
+	_, err = clog.Append(&api.Record{Value: []byte("fourth")})
+	require.NoError(t, err)
+	require.Equal(t, WSMessage{Type: wsRecord, Offset: 3, Value: []byte("fourth")}, recv())
+
+	send(WSMessage{Type: "nope", ID: "x"})
+	msg = recv()
+	require.Equal(t, wsError, msg.Type)
+	require.Equal(t, CodeBadRequest, msg.Code)
+	require.Equal(t, "x", msg.ID)
+}
+
+func TestWebSocketRateLimits(t *testing.T) {
+	h, clog, teardown := setupTest(t, func(c *Config) {
+		c.RateLimits = &RateLimits{
+			ProduceRequests: Limit{Rate: 0.01, Burst: 1},
+			ConsumeRequests: Limit{Rate: 20, Burst: 1},
+		}
+	})
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	recv := func() WSMessage {
+		t.Helper()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var msg WSMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	// every produce message is a produce request
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "1", Value: []byte("first")}))
+	require.Equal(t, wsAck, recv().Type)
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "2", Value: []byte("second")}))
+	msg := recv()
+	require.Equal(t, wsError, msg.Type)
+	require.Equal(t, "2", msg.ID)
+	require.Equal(t, CodeRateLimited, msg.Code)
+
+	// and every record sent a consume request, the subscription waits for the bucket instead of
+	// ending
+	for i := 0; i < 4; i++ {
+		_, err = clog.Append(&api.Record{Value: []byte("more")})
+		require.NoError(t, err)
+	}
+	start := time.Now()
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsSubscribe, Offset: 0, Credit: 5}))
+	for off := uint64(0); off < 5; off++ {
+		msg = recv()
+		require.Equal(t, wsRecord, msg.Type)
+		require.Equal(t, off, msg.Offset)
+	}
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestWebSocketBadMessages(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	recv := func() WSMessage {
+		t.Helper()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var msg WSMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	// messages that can't be decoded are refused, the connection stays open
+	for _, bad := range []string{
+		`{"type": "produce"`,
+		`{"type": "subscribe", "offset": "x"}`,
+		`{"type": "produce", "value": "not base64!"}`,
+	} {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(bad)))
+		msg := recv()
+		require.Equal(t, wsError, msg.Type, bad)
+		require.Equal(t, CodeBadRequest, msg.Code, bad)
+	}
+
+	// a record as big as the log takes fits in a message
+	value := make([]byte, clog.MaxRecordBytes()-16)
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "1", Value: value}))
+	require.Equal(t, wsAck, recv().Type)
+
+	// a message bigger than any request body ends the connection
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "2", Value: make([]byte, 8<<10)}))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg WSMessage
+	require.Error(t, conn.ReadJSON(&msg))
+}
+
+func TestWebSocketLatestEmptyLog(t *testing.T) {
+	h, clog, teardown := setupTest(t, nil)
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// the log is left with an empty active segment at offset 2
+	for _, v := range []string{"first", "second"} {
+		_, err := clog.Append(&api.Record{Value: []byte(v)})
+		require.NoError(t, err)
+	}
+	_, err := clog.Roll()
+	require.NoError(t, err)
+	require.NoError(t, clog.Truncate(1))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsSubscribe, Latest: true, Credit: 1}))
+	// the subscription has started once a produce message sent after it is acknowledged
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "1", Value: []byte("third")}))
+	var got []WSMessage
+	for len(got) < 2 {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var msg WSMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		got = append(got, msg)
+	}
+	require.ElementsMatch(t, []WSMessage{
+		{Type: wsAck, ID: "1", Offset: 2},
+		{Type: wsRecord, Offset: 2, Value: []byte("third")},
+	}, got)
+}
+
+func TestWebSocketOutlivesRequestTimeout(t *testing.T) {
+	h, _, teardown := setupTest(t, func(c *Config) { c.RequestTimeout = 20 * time.Millisecond })
+	defer teardown()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, conn.WriteJSON(WSMessage{Type: wsProduce, ID: "1", Value: []byte("late")}))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg WSMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, WSMessage{Type: wsAck, ID: "1", Offset: 0}, msg)
+}
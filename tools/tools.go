@@ -0,0 +1,12 @@
+//go:build tools
+// +build tools
+
+// Package tools pins the versions of the code generators of `make compile` in go.mod, so the
+// generated code doesn't depend on what happens to be installed
+package tools
+
+import (
+	_ "github.com/jhump/goprotoc/cmd/goprotoc"
+	_ "google.golang.org/grpc/cmd/protoc-gen-go-grpc"
+	_ "google.golang.org/protobuf/cmd/protoc-gen-go"
+)